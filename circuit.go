@@ -0,0 +1,327 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit for one host
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every call straight away
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial calls through to see whether the
+	// host has recovered
+	CircuitHalfOpen
+)
+
+// String returns closed, open or half-open
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// MarshalText lets states show up by name in health check JSON
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CircuitOpenError is returned instead of making a call to a host whose
+// circuit is open. ErrorJSON sends it as a 503.
+type CircuitOpenError struct {
+	Host string
+	// RetryAfter is roughly how long until the circuit lets a trial
+	// call through
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s, retry in %s", e.Host, e.RetryAfter.Round(time.Second))
+}
+
+// ProblemDetails reports an open circuit as 503 Service Unavailable
+func (e *CircuitOpenError) ProblemDetails() *Problem {
+	return &Problem{
+		Status: http.StatusServiceUnavailable,
+		Detail: fmt.Sprintf("upstream service %s is unavailable", e.Host),
+	}
+}
+
+// CircuitBreaker stops calls to a host that keeps failing, so callers
+// fail fast instead of piling up behind it. Each host has its own
+// circuit. A circuit opens when at least FailureRatio of the calls in
+// the current Window failed, stays open for Cooldown, then goes half-open
+// and lets HalfOpenRequests trial calls through: if they all succeed it
+// closes again, and if any fails it opens for another Cooldown.
+//
+// The zero value is ready to use. Set it on Tools.CircuitBreaker to
+// guard PushJSONToRemote, or wrap any client with Transport:
+//
+//	breaker := &toolkit.CircuitBreaker{Cooldown: 10 * time.Second}
+//	client := &http.Client{Transport: breaker.Transport(nil)}
+type CircuitBreaker struct {
+	// FailureRatio is the share of failed calls, between 0 and 1, that
+	// opens a circuit. Default is 0.5
+	FailureRatio float64
+	// MinRequests is how many calls a window needs before FailureRatio
+	// is checked, so one early failure doesn't open the circuit. Default
+	// is 10
+	MinRequests int
+	// Window is how long calls are counted for before the counts start
+	// over. Default is one minute
+	Window time.Duration
+	// Cooldown is how long a circuit stays open. Default is 30 seconds
+	Cooldown time.Duration
+	// HalfOpenRequests is how many trial calls a half-open circuit lets
+	// through. Default is 1
+	HalfOpenRequests int
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state kept for one host
+type circuit struct {
+	state CircuitState
+	// generation goes up with every change of state, so a call that
+	// started in an earlier state can't count towards this one
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// trials and successes count calls while half-open
+	trials    int
+	successes int
+}
+
+// CircuitCall is a call to a host that Allow let through
+type CircuitCall struct {
+	host       string
+	generation uint64
+}
+
+// Allow returns a *CircuitOpenError if a call to host must not be made
+// now. Every call that is allowed must be finished with Record, or with
+// Release if it was given up before it said anything about the host.
+func (b *CircuitBreaker) Allow(host string) (CircuitCall, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(host)
+	now := time.Now()
+
+	switch c.state {
+	case CircuitOpen:
+		cooldown := b.cooldown()
+		if wait := c.openedAt.Add(cooldown).Sub(now); wait > 0 {
+			return CircuitCall{}, &CircuitOpenError{Host: host, RetryAfter: wait}
+		}
+		c.state = CircuitHalfOpen
+		c.generation++
+		c.trials, c.successes = 0, 0
+		fallthrough
+	case CircuitHalfOpen:
+		if c.trials >= b.halfOpenRequests() {
+			// the trial calls are still out; hold everyone else back
+			return CircuitCall{}, &CircuitOpenError{Host: host}
+		}
+		c.trials++
+	default:
+		window := time.Minute
+		if b.Window != 0 {
+			window = b.Window
+		}
+		if now.Sub(c.windowStart) > window {
+			c.windowStart = now
+			c.requests, c.failures = 0, 0
+		}
+	}
+	return CircuitCall{host: host, generation: c.generation}, nil
+}
+
+// Record reports how an allowed call went. Calls that started before the
+// circuit last changed state are ignored
+func (b *CircuitBreaker) Record(call CircuitCall, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(call.host)
+	if call.generation != c.generation {
+		return
+	}
+
+	switch c.state {
+	case CircuitHalfOpen:
+		if !success {
+			b.open(c)
+			return
+		}
+		c.successes++
+		if c.successes >= b.halfOpenRequests() {
+			c.state = CircuitClosed
+			c.generation++
+			c.windowStart = time.Now()
+			c.requests, c.failures = 0, 0
+		}
+	case CircuitClosed:
+		c.requests++
+		if !success {
+			c.failures++
+		}
+
+		ratio := 0.5
+		if b.FailureRatio != 0 {
+			ratio = b.FailureRatio
+		}
+		minRequests := 10
+		if b.MinRequests != 0 {
+			minRequests = b.MinRequests
+		}
+		if c.requests >= minRequests && float64(c.failures)/float64(c.requests) >= ratio {
+			b.open(c)
+		}
+	}
+}
+
+// Release finishes an allowed call without counting it, e.g. one the
+// caller canceled. A trial call's slot is given to the next caller
+func (b *CircuitBreaker) Release(call CircuitCall) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(call.host)
+	if call.generation == c.generation && c.state == CircuitHalfOpen && c.trials > 0 {
+		c.trials--
+	}
+}
+
+// State returns the state of the circuit for host. A circuit whose
+// cooldown is over reports half-open, even before the next call
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[host]
+	if !ok {
+		return CircuitClosed
+	}
+	return b.stateOf(c)
+}
+
+// States returns the state of every host the breaker has seen, for use
+// in health checks:
+//
+//	_ = tools.WriteJson(w, http.StatusOK, breaker.States())
+func (b *CircuitBreaker) States() map[string]CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make(map[string]CircuitState, len(b.circuits))
+	for host, c := range b.circuits {
+		states[host] = b.stateOf(c)
+	}
+	return states
+}
+
+// Transport wraps base, or http.DefaultTransport if base is nil, so every
+// request goes through the breaker for its host. Network errors, timeouts
+// and 5xx responses count as failures, and requests canceled by the
+// caller don't count at all; a request whose circuit is open fails with
+// a *CircuitOpenError without being sent.
+func (b *CircuitBreaker) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &breakerTransport{breaker: b, base: base}
+}
+
+// breakerTransport is the http.RoundTripper returned by Transport
+type breakerTransport struct {
+	breaker *CircuitBreaker
+	base    http.RoundTripper
+}
+
+func (bt *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	call, err := bt.breaker.Allow(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bt.base.RoundTrip(req)
+	// Client.Timeout shows up here as a deadline on the request's
+	// context, so only a cancel is taken as the caller giving up
+	bt.breaker.finish(call, resp, err, errors.Is(req.Context().Err(), context.Canceled))
+	return resp, err
+}
+
+// finish records how a call through the breaker went. Network errors,
+// timeouts and 5xx responses are failures. A call that failed because
+// the caller gave up says nothing about the host, so it's released
+func (b *CircuitBreaker) finish(call CircuitCall, resp *http.Response, err error, gaveUp bool) {
+	switch {
+	case err != nil && gaveUp:
+		b.Release(call)
+	case err != nil:
+		b.Record(call, false)
+	default:
+		b.Record(call, resp.StatusCode < http.StatusInternalServerError)
+	}
+}
+
+// circuit returns the circuit for host, creating it if needed. b.mu
+// must be held
+func (b *CircuitBreaker) circuit(host string) *circuit {
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[host] = c
+	}
+	return c
+}
+
+// open opens c. b.mu must be held
+func (b *CircuitBreaker) open(c *circuit) {
+	c.state = CircuitOpen
+	c.generation++
+	c.openedAt = time.Now()
+	c.requests, c.failures = 0, 0
+}
+
+// stateOf is State without the lock
+func (b *CircuitBreaker) stateOf(c *circuit) CircuitState {
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.cooldown() {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown != 0 {
+		return b.Cooldown
+	}
+	return 30 * time.Second
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests != 0 {
+		return b.HalfOpenRequests
+	}
+	return 1
+}
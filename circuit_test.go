@@ -0,0 +1,232 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := CircuitBreaker{MinRequests: 4, FailureRatio: 0.5, Cooldown: 20 * time.Millisecond}
+
+	// two failures out of four opens the circuit
+	for _, ok := range []bool{true, false, true, false} {
+		call, err := breaker.Allow("api")
+		if err != nil {
+			t.Fatalf("closed circuit refused a call: %s", err)
+		}
+		breaker.Record(call, ok)
+	}
+	if breaker.State("api") != CircuitOpen {
+		t.Fatalf("expected open but got %s", breaker.State("api"))
+	}
+
+	var openError *CircuitOpenError
+	if _, err := breaker.Allow("api"); !errors.As(err, &openError) || openError.Host != "api" {
+		t.Errorf("expected a circuit open error but got %v", err)
+	}
+
+	// other hosts are unaffected
+	other, err := breaker.Allow("other")
+	if err != nil {
+		t.Errorf("other host refused: %s", err)
+	}
+	breaker.Record(other, true)
+
+	// after the cooldown one trial call goes through, and a failure
+	// opens the circuit again
+	time.Sleep(30 * time.Millisecond)
+	if breaker.State("api") != CircuitHalfOpen {
+		t.Errorf("expected half-open but got %s", breaker.State("api"))
+	}
+	trial, err := breaker.Allow("api")
+	if err != nil {
+		t.Fatalf("half-open circuit refused the trial call: %s", err)
+	}
+	if _, err := breaker.Allow("api"); err == nil {
+		t.Error("half-open circuit allowed a second call")
+	}
+	breaker.Record(trial, false)
+	if breaker.State("api") != CircuitOpen {
+		t.Errorf("expected open again but got %s", breaker.State("api"))
+	}
+
+	// and a successful trial closes it
+	time.Sleep(30 * time.Millisecond)
+	trial, err = breaker.Allow("api")
+	if err != nil {
+		t.Fatalf("half-open circuit refused the trial call: %s", err)
+	}
+	breaker.Record(trial, true)
+
+	out, _ := json.Marshal(breaker.States())
+	if string(out) != `{"api":"closed","other":"closed"}` {
+		t.Errorf("wrong states: %s", out)
+	}
+}
+
+func TestCircuitBreaker_Generations(t *testing.T) {
+	breaker := CircuitBreaker{MinRequests: 1, Cooldown: 20 * time.Millisecond}
+
+	// a slow call starts while the circuit is closed, then it opens
+	slow, _ := breaker.Allow("api")
+	failed, _ := breaker.Allow("api")
+	breaker.Record(failed, false)
+	if breaker.State("api") != CircuitOpen {
+		t.Fatalf("expected open but got %s", breaker.State("api"))
+	}
+
+	// the slow call finishing doesn't count as the half-open trial
+	time.Sleep(30 * time.Millisecond)
+	trial, err := breaker.Allow("api")
+	if err != nil {
+		t.Fatalf("half-open circuit refused the trial call: %s", err)
+	}
+	breaker.Record(slow, true)
+	if breaker.State("api") != CircuitHalfOpen {
+		t.Errorf("a call from before the circuit opened closed it: %s", breaker.State("api"))
+	}
+
+	// a trial the caller gave up on frees its slot without changing the
+	// state
+	breaker.finish(trial, nil, context.Canceled, true)
+	if breaker.State("api") != CircuitHalfOpen {
+		t.Errorf("expected half-open after a canceled trial but got %s", breaker.State("api"))
+	}
+	trial, err = breaker.Allow("api")
+	if err != nil {
+		t.Fatalf("canceled trial kept its slot: %s", err)
+	}
+
+	// but one that timed out by itself is a failure
+	breaker.finish(trial, nil, context.DeadlineExceeded, false)
+	if breaker.State("api") != CircuitOpen {
+		t.Errorf("expected open after a timed out trial but got %s", breaker.State("api"))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	trial, _ = breaker.Allow("api")
+	breaker.Record(trial, true)
+	if breaker.State("api") != CircuitClosed {
+		t.Errorf("expected closed but got %s", breaker.State("api"))
+	}
+}
+
+func TestCircuitBreaker_Transport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	breaker := &CircuitBreaker{MinRequests: 2, Cooldown: time.Minute}
+	client := &http.Client{Transport: breaker.Transport(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(srv.URL)
+	var openError *CircuitOpenError
+	if !errors.As(err, &openError) {
+		t.Errorf("expected a circuit open error but got %v", err)
+	}
+}
+
+func TestTools_PushJSONToRemoteCircuitOpen(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	testTools := Tools{
+		RemoteBackoff:  time.Millisecond,
+		CircuitBreaker: &CircuitBreaker{MinRequests: 2, Cooldown: time.Minute},
+	}
+
+	// the circuit opens after two failures, so the last retry is never
+	// sent
+	_, err := testTools.PushJSONToRemote(context.Background(), "PUT", srv.URL, nil, nil)
+	var openError *CircuitOpenError
+	if !errors.As(err, &openError) || calls != 2 {
+		t.Fatalf("expected a circuit open error after 2 calls but got %v after %d", err, calls)
+	}
+
+	// and later calls fail straight away
+	_, err = testTools.PushJSONToRemote(context.Background(), "POST", srv.URL, nil, nil)
+	if !errors.As(err, &openError) || calls != 2 {
+		t.Fatalf("expected a circuit open error but got %v after %d calls", err, calls)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 but got %d", rr.Code)
+	}
+}
+
+func TestTools_PushJSONToRemoteHangingHost(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	testTools := Tools{
+		RemoteTimeout:  30 * time.Millisecond,
+		RemoteRetries:  -1,
+		CircuitBreaker: &CircuitBreaker{MinRequests: 2, Cooldown: time.Minute},
+	}
+
+	// each attempt times out, which counts against the host
+	for i := 0; i < 2; i++ {
+		_, err := testTools.PushJSONToRemote(context.Background(), "GET", srv.URL, nil, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected a timeout but got %v", err)
+		}
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if state := testTools.CircuitBreaker.State(host); state != CircuitOpen {
+		t.Errorf("expected a hanging host to open the circuit but it's %s", state)
+	}
+
+	// a caller giving up doesn't
+	other := &CircuitBreaker{MinRequests: 1, Cooldown: time.Minute}
+	client := &http.Client{Transport: other.Transport(nil)}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("expected the canceled request to fail")
+	}
+	if state := other.State(host); state != CircuitClosed {
+		t.Errorf("expected a canceled call not to count but the circuit is %s", state)
+	}
+
+	// and Client.Timeout is the host's fault
+	client.Timeout = 30 * time.Millisecond
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if state := other.State(host); state != CircuitOpen {
+		t.Errorf("expected Client.Timeout to count but the circuit is %s", state)
+	}
+}
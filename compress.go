@@ -0,0 +1,212 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// openBody is the start of every body reader: it limits the request
+// body to maxBytes, undoes its Content-Encoding and, when
+// WebhookVerifier is set, checks its signature
+func (t *Tools) openBody(w http.ResponseWriter, r *http.Request, maxBytes int) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	body, err := decompressBody(w, r, r.Body, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return t.verifyBody(r, body, maxBytes)
+}
+
+// verifyBody checks body against the request's webhook signature, when
+// WebhookVerifier is set. The signature covers the body exactly as it
+// was signed, so it's read in full and a reader over it is returned
+func (t *Tools) verifyBody(r *http.Request, body io.ReadCloser, maxBytes int) (io.ReadCloser, error) {
+	if t.WebhookVerifier == nil {
+		return body, nil
+	}
+	defer body.Close()
+
+	// a request that can't pass is turned away before its body is read
+	if _, _, err := t.WebhookVerifier.checkHeaders(r.Header); err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, decodeError(err, nil, maxBytes)
+	}
+	if err := t.WebhookVerifier.Verify(r.Header, raw); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(raw)), nil
+}
+
+// decompressBody undoes the Content-Encoding of a request body. gzip and
+// deflate are supported; anything else is rejected with a 415. The
+// decompressed body is limited to maxBytes as well, so the usual "body
+// must not be larger than" error is returned for gzip bombs.
+func decompressBody(w http.ResponseWriter, r *http.Request, body io.ReadCloser, maxBytes int) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	var decompressed io.ReadCloser
+	var err error
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		decompressed, err = gzip.NewReader(body)
+	case "deflate":
+		// HTTP's deflate is really the zlib format
+		decompressed, err = zlib.NewReader(body)
+	default:
+		return nil, unsupportedMediaType("mediatype.encoding", encoding)
+	}
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil, badRequest("json.empty")
+		case errors.As(err, &maxBytesError):
+			return nil, badRequest("json.too_large", maxBytes)
+		default:
+			return nil, badRequest("body.bad_encoding", encoding)
+		}
+	}
+
+	return http.MaxBytesReader(w, &decompressReader{ReadCloser: decompressed, encoding: encoding}, int64(maxBytes)), nil
+}
+
+// decompressReader reports compressed data that's corrupt or cut short,
+// which the decompressors only find part way through the body, as a bad
+// request
+type decompressReader struct {
+	io.ReadCloser
+	encoding string
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if err != nil && corruptCompression(err) {
+		err = badRequest("body.bad_encoding", d.encoding)
+	}
+	return n, err
+}
+
+// corruptCompression reports whether err means the compressed data is
+// bad, rather than that it ended or was too large
+func corruptCompression(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.As(err, &corrupt) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, zlib.ErrChecksum) || errors.Is(err, zlib.ErrHeader) || errors.Is(err, zlib.ErrDictionary)
+}
+
+// compressResponse compresses out if CompressJSON is set, the body is
+// big enough to be worth it, and the client accepts gzip or deflate. It
+// sets the Content-Encoding and Vary headers to match.
+func (t *Tools) compressResponse(w http.ResponseWriter, r *http.Request, out []byte) ([]byte, error) {
+	if !t.CompressJSON {
+		return out, nil
+	}
+
+	minSize := 1024 // 1KiB
+	if t.CompressMinSize != 0 {
+		minSize = t.CompressMinSize
+	}
+	if len(out) < minSize || w.Header().Get("Content-Encoding") != "" {
+		return out, nil
+	}
+
+	// caches need to know the body depends on Accept-Encoding
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return out, nil
+	}
+
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	if encoding == "gzip" {
+		zw = gzip.NewWriter(&buf)
+	} else {
+		zw = zlib.NewWriter(&buf)
+	}
+	if _, err := zw.Write(out); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	// the length of the uncompressed body would be wrong now
+	w.Header().Del("Content-Length")
+	return buf.Bytes(), nil
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// honouring q values. gzip wins a tie. An empty string means the client
+// didn't ask for either
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q := parseQuality(part)
+		switch name {
+		case "gzip", "x-gzip":
+			name = "gzip"
+		case "deflate":
+		case "*":
+			wildcard = q
+			continue
+		default:
+			continue
+		}
+
+		seen[name] = true
+		if q > bestQ || (q == bestQ && q > 0 && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+
+	// a wildcard covers anything not named explicitly
+	if wildcard > bestQ {
+		for _, name := range []string{"gzip", "deflate"} {
+			if !seen[name] {
+				return name
+			}
+		}
+	}
+	return best
+}
+
+// parseQuality splits an Accept style list element such as "gzip;q=0.8"
+// into its lower cased value and q value. A missing q means 1
+func parseQuality(part string) (string, float64) {
+	value, params, _ := strings.Cut(part, ";")
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return value, q
+}
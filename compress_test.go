@@ -0,0 +1,154 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func zlibBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// corrupt flips a bit in b at i, counting from the end if i is negative
+func corrupt(b []byte, i int) []byte {
+	out := append([]byte(nil), b...)
+	if i < 0 {
+		i += len(out)
+	}
+	out[i] ^= 0xff
+	return out
+}
+
+var decompressTests = []struct {
+	name           string
+	encoding       string
+	body           []byte
+	maxSize        int
+	expectedStatus int
+	expectedError  string
+}{
+	{name: "gzip", encoding: "gzip", body: gzipBytes([]byte(`{"foo": "bar"}`)), maxSize: 1024, expectedStatus: 0},
+	{name: "deflate", encoding: "deflate", body: zlibBytes([]byte(`{"foo": "bar"}`)), maxSize: 1024, expectedStatus: 0},
+	{name: "identity", encoding: "identity", body: []byte(`{"foo": "bar"}`), maxSize: 1024, expectedStatus: 0},
+	{name: "not gzip", encoding: "gzip", body: []byte(`{"foo": "bar"}`), maxSize: 1024, expectedStatus: http.StatusBadRequest, expectedError: "body is not valid gzip data"},
+	{name: "empty gzip", encoding: "gzip", body: []byte{}, maxSize: 1024, expectedStatus: http.StatusBadRequest},
+	{name: "corrupt deflate data", encoding: "deflate", body: append(zlibBytes(nil)[:2], 0xff, 0xff, 0xff, 0xff), maxSize: 1024, expectedStatus: http.StatusBadRequest, expectedError: "body is not valid deflate data"},
+	{name: "corrupt gzip checksum", encoding: "gzip", body: corrupt(gzipBytes([]byte(`{"foo": "bar"}`)), -8), maxSize: 1024, expectedStatus: http.StatusBadRequest, expectedError: "body is not valid gzip data"},
+	{name: "truncated gzip", encoding: "gzip", body: gzipBytes([]byte(`{"foo": "bar"}`))[:20], maxSize: 1024, expectedStatus: http.StatusBadRequest, expectedError: "body is not valid gzip data"},
+	{name: "unknown encoding", encoding: "br", body: []byte(`{"foo": "bar"}`), maxSize: 1024, expectedStatus: http.StatusUnsupportedMediaType},
+	// a few hundred bytes of gzip that decompress to 1MiB
+	{name: "gzip bomb", encoding: "gzip", body: gzipBytes([]byte(`{"foo": "` + strings.Repeat("a", 1024*1024) + `"}`)), maxSize: 64 * 1024, expectedStatus: http.StatusBadRequest},
+}
+
+func TestTools_ReadJSONDecompress(t *testing.T) {
+	for _, e := range decompressTests {
+		testTools := Tools{MaxJSONSize: e.maxSize}
+
+		var decoded struct {
+			Foo string `json:"foo"`
+		}
+
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(e.body))
+		req.Header.Set("Content-Encoding", e.encoding)
+		rr := httptest.NewRecorder()
+
+		err := testTools.ReadJson(rr, req, &decoded)
+		var p *Problem
+		if e.expectedStatus != 0 && (!errors.As(err, &p) || p.Status != e.expectedStatus) {
+			t.Errorf("%s: expected a %d problem but got %v", e.name, e.expectedStatus, err)
+		}
+		if e.expectedError != "" && (err == nil || err.Error() != e.expectedError) {
+			t.Errorf("%s: expected %q but got %v", e.name, e.expectedError, err)
+		}
+		if e.expectedStatus == 0 {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err.Error())
+			} else if decoded.Foo != "bar" {
+				t.Errorf("%s: wrong value decoded: %s", e.name, decoded.Foo)
+			}
+		}
+	}
+
+	// the bomb should give us the usual size error
+	testTools := Tools{MaxJSONSize: 1024}
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader(gzipBytes(bytes.Repeat([]byte(" "), 4096))))
+	req.Header.Set("Content-Encoding", "gzip")
+	err := testTools.ReadJson(httptest.NewRecorder(), req, &struct{}{})
+	if err == nil || err.Error() != "body must not be larger than 1024 bytes" {
+		t.Errorf("wrong error for oversized body: %v", err)
+	}
+}
+
+var compressTests = []struct {
+	name             string
+	acceptEncoding   string
+	size             int
+	expectedEncoding string
+}{
+	{name: "gzip", acceptEncoding: "gzip, deflate", size: 2048, expectedEncoding: "gzip"},
+	{name: "deflate preferred", acceptEncoding: "gzip;q=0.5, deflate", size: 2048, expectedEncoding: "deflate"},
+	{name: "gzip refused", acceptEncoding: "gzip;q=0, deflate;q=0", size: 2048, expectedEncoding: ""},
+	{name: "wildcard", acceptEncoding: "*", size: 2048, expectedEncoding: "gzip"},
+	{name: "no header", acceptEncoding: "", size: 2048, expectedEncoding: ""},
+	{name: "too small", acceptEncoding: "gzip", size: 10, expectedEncoding: ""},
+}
+
+func TestTools_ServeJSONCompress(t *testing.T) {
+	testTools := Tools{CompressJSON: true}
+
+	for _, e := range compressTests {
+		payload := JSONResponse{Message: strings.Repeat("a", e.size)}
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		if e.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", e.acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+
+		if err := testTools.ServeJSON(rr, req, http.StatusOK, payload); err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if got := rr.Header().Get("Content-Encoding"); got != e.expectedEncoding {
+			t.Errorf("%s: expected encoding %q but got %q", e.name, e.expectedEncoding, got)
+			continue
+		}
+
+		var body io.Reader = rr.Body
+		switch e.expectedEncoding {
+		case "gzip":
+			body, _ = gzip.NewReader(rr.Body)
+		case "deflate":
+			body, _ = zlib.NewReader(rr.Body)
+		}
+
+		var decoded JSONResponse
+		if err := json.NewDecoder(body).Decode(&decoded); err != nil {
+			t.Errorf("%s: error decoding response: %s", e.name, err)
+		}
+		if decoded.Message != payload.Message {
+			t.Errorf("%s: wrong message decoded", e.name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrorClass is what ErrorJSON sends for a registered error
+type ErrorClass struct {
+	// Status is the HTTP status code. Zero keeps the status ErrorJSON
+	// would otherwise use
+	Status int
+	// Message is shown to the client instead of the error's own
+	// message. Empty keeps the error's message
+	Message string
+	// Code is a stable, machine readable code such as "not_found", sent
+	// in the code member
+	Code string
+}
+
+// ErrorRegistry maps errors to the status, public message and code
+// ErrorJSON sends for them, so handlers can pass errors through as they
+// are without leaking what's inside them:
+//
+//	reg := toolkit.NewErrorRegistry()
+//	reg.Register(sql.ErrNoRows, toolkit.ErrorClass{Status: 404, Message: "not found", Code: "not_found"})
+//	toolkit.RegisterErrorType[*fs.PathError](reg, toolkit.ErrorClass{Status: 500, Message: "storage error", Code: "storage"})
+//	tools := toolkit.Tools{Errors: reg, Production: true}
+//
+// Errors are matched through wrapping, in the order they were
+// registered.
+type ErrorRegistry struct {
+	mu      sync.RWMutex
+	entries []errorEntry
+}
+
+// errorEntry is one registration
+type errorEntry struct {
+	matches func(err error) bool
+	class   ErrorClass
+}
+
+// NewErrorRegistry returns an empty registry
+func NewErrorRegistry() *ErrorRegistry {
+	return &ErrorRegistry{}
+}
+
+// Register classifies every error that errors.Is matches with target,
+// usually a sentinel such as sql.ErrNoRows
+func (reg *ErrorRegistry) Register(target error, class ErrorClass) {
+	reg.RegisterFunc(func(err error) bool { return errors.Is(err, target) }, class)
+}
+
+// RegisterFunc classifies every error match returns true for
+func (reg *ErrorRegistry) RegisterFunc(match func(err error) bool, class ErrorClass) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.entries = append(reg.entries, errorEntry{matches: match, class: class})
+}
+
+// RegisterErrorType classifies every error that errors.As finds an E in.
+// It's a function rather than a method because methods can't have type
+// parameters
+func RegisterErrorType[E error](reg *ErrorRegistry, class ErrorClass) {
+	reg.RegisterFunc(func(err error) bool {
+		var target E
+		return errors.As(err, &target)
+	}, class)
+}
+
+// Lookup returns the class of the first registration that matches err. A
+// nil registry matches nothing
+func (reg *ErrorRegistry) Lookup(err error) (ErrorClass, bool) {
+	if reg == nil {
+		return ErrorClass{}, false
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	for _, e := range reg.entries {
+		if e.matches(err) {
+			return e.class, true
+		}
+	}
+	return ErrorClass{}, false
+}
+
+// withDetail returns a copy of problem with its detail replaced, so the
+// error's own value is never changed
+func withDetail(problem *Problem, detail string) *Problem {
+	var p Problem
+	if problem != nil {
+		p = *problem
+	}
+	p.Detail = detail
+	return &p
+}
+
+// randomID returns a random ID for matching a client's request or error
+// response to the server log
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
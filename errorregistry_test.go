@@ -0,0 +1,101 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var errNotFound = errors.New("sql: no rows in result set")
+
+var errorRegistryTests = []struct {
+	name            string
+	production      bool
+	err             error
+	status          []int
+	expectedStatus  int
+	expectedMessage string
+	expectedCode    string
+	correlation     bool
+}{
+	{name: "sentinel", err: fmt.Errorf("loading user: %w", errNotFound), expectedStatus: http.StatusNotFound, expectedMessage: "not found", expectedCode: "not_found"},
+	{name: "type", err: &fs.PathError{Op: "open", Path: "/var/data/x", Err: fs.ErrPermission}, expectedStatus: http.StatusInternalServerError, expectedMessage: "storage error", expectedCode: "storage"},
+	{name: "unknown in development", err: errors.New("open /var/data/x: permission denied"), expectedStatus: http.StatusBadRequest, expectedMessage: "open /var/data/x: permission denied"},
+	{name: "unknown in production", production: true, err: errors.New("open /var/data/x: permission denied"), expectedStatus: http.StatusInternalServerError, expectedMessage: "internal server error", correlation: true},
+	{name: "unknown with 500", production: true, err: errors.New("dial tcp 10.0.0.1:5432"), status: []int{http.StatusBadGateway}, expectedStatus: http.StatusInternalServerError, expectedMessage: "internal server error", correlation: true},
+	{name: "unknown with 400", production: true, err: errors.New("pq: duplicate key value violates unique constraint"), status: []int{http.StatusBadRequest}, expectedStatus: http.StatusInternalServerError, expectedMessage: "internal server error", correlation: true},
+	{name: "caller problem", production: true, err: &Problem{Status: http.StatusNotFound, Detail: "no such widget"}, status: []int{http.StatusNotFound}, expectedStatus: http.StatusNotFound, expectedMessage: "no such widget"},
+	{name: "registered in production", production: true, err: errNotFound, expectedStatus: http.StatusNotFound, expectedMessage: "not found", expectedCode: "not_found"},
+	{name: "decode error in production", production: true, err: badRequest("json.empty"), expectedStatus: http.StatusBadRequest, expectedMessage: "body must not be empty"},
+	{name: "remote error in production", production: true, err: &RemoteError{StatusCode: 500, Body: []byte("secret stack")}, expectedStatus: http.StatusBadGateway, expectedMessage: "upstream service returned 500"},
+}
+
+func TestTools_ErrorJSONRegistry(t *testing.T) {
+	reg := NewErrorRegistry()
+	reg.Register(errNotFound, ErrorClass{Status: http.StatusNotFound, Message: "not found", Code: "not_found"})
+	RegisterErrorType[*fs.PathError](reg, ErrorClass{Status: http.StatusInternalServerError, Message: "storage error", Code: "storage"})
+
+	for _, e := range errorRegistryTests {
+		testTools := Tools{Errors: reg, Production: e.production}
+
+		rr := httptest.NewRecorder()
+		if err := testTools.ErrorJSON(rr, e.err, e.status...); err != nil {
+			t.Fatal(err)
+		}
+
+		var payload JSONResponse
+		_ = json.NewDecoder(rr.Body).Decode(&payload)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if payload.Message != e.expectedMessage || payload.Code != e.expectedCode {
+			t.Errorf("%s: expected %q (%s) but got %q (%s)", e.name, e.expectedMessage, e.expectedCode, payload.Message, payload.Code)
+		}
+		if e.correlation != (payload.CorrelationID != "") {
+			t.Errorf("%s: wrong correlation ID %q", e.name, payload.CorrelationID)
+		}
+	}
+}
+
+func TestTools_ErrorJSONRegistryProblem(t *testing.T) {
+	reg := NewErrorRegistry()
+	reg.Register(errNotFound, ErrorClass{Status: http.StatusNotFound, Message: "not found", Code: "not_found"})
+	testTools := Tools{Errors: reg, Production: true, ProblemJSON: true}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, errNotFound)
+	body := rr.Body.String()
+	if rr.Code != http.StatusNotFound || !strings.Contains(body, `"code":"not_found"`) || !strings.Contains(body, `"detail":"not found"`) {
+		t.Errorf("wrong problem for registered error: %d %s", rr.Code, body)
+	}
+
+	rr = httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, errors.New("open /var/data/x: permission denied"))
+	body = rr.Body.String()
+	if rr.Code != http.StatusInternalServerError || strings.Contains(body, "/var/data") || !strings.Contains(body, `"correlation_id"`) {
+		t.Errorf("wrong problem for unknown error: %d %s", rr.Code, body)
+	}
+}
+
+// classified and hidden errors keep their code and correlation ID when
+// the response is written as XML
+func TestJSONResponse_MarshalXMLErrorClass(t *testing.T) {
+	payload := JSONResponse{Error: true, Message: "internal server error", Code: "storage", CorrelationID: "abc-123"}
+
+	out, err := xml.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<response><error>true</error><message>internal server error</message>` +
+		`<code>storage</code><correlation_id>abc-123</correlation_id></response>`
+	if string(out) != expected {
+		t.Errorf("wrong XML written:\n%s", out)
+	}
+}
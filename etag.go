@@ -0,0 +1,49 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// bodyETag returns a weak ETag for an encoded body. It's weak because
+// the same body may go out gzipped, deflated or as is, and a strong ETag
+// would have to differ between them
+func bodyETag(out []byte) string {
+	sum := sha256.Sum256(out)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets an ETag for out, unless one was supplied in the
+// headers, and reports whether it matches the request's If-None-Match
+// header. Only successful GET and HEAD responses take part
+func (t *Tools) notModified(w http.ResponseWriter, r *http.Request, responseStatus int, out []byte) bool {
+	if !t.JSONETags || responseStatus != http.StatusOK || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		etag = bodyETag(out)
+		w.Header().Set("ETag", etag)
+	}
+
+	return etagMatches(r.Header.Get("If-None-Match"), etag)
+}
+
+// etagMatches compares an If-None-Match header with etag using the weak
+// comparison RFC 9110 asks for
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
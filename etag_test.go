@@ -0,0 +1,81 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var etagTests = []struct {
+	name           string
+	method         string
+	status         int
+	ifNoneMatch    string
+	expectedStatus int
+	expectETag     bool
+}{
+	{name: "first request", method: "GET", status: http.StatusOK, expectedStatus: http.StatusOK, expectETag: true},
+	{name: "matching", method: "GET", status: http.StatusOK, ifNoneMatch: "match", expectedStatus: http.StatusNotModified, expectETag: true},
+	{name: "strong form matches", method: "GET", status: http.StatusOK, ifNoneMatch: "strong", expectedStatus: http.StatusNotModified, expectETag: true},
+	{name: "one of several", method: "GET", status: http.StatusOK, ifNoneMatch: `"abc", match`, expectedStatus: http.StatusNotModified, expectETag: true},
+	{name: "star", method: "HEAD", status: http.StatusOK, ifNoneMatch: "*", expectedStatus: http.StatusNotModified, expectETag: true},
+	{name: "stale", method: "GET", status: http.StatusOK, ifNoneMatch: `W/"abc"`, expectedStatus: http.StatusOK, expectETag: true},
+	{name: "post", method: "POST", status: http.StatusOK, ifNoneMatch: "match", expectedStatus: http.StatusOK},
+	{name: "created", method: "GET", status: http.StatusCreated, ifNoneMatch: "match", expectedStatus: http.StatusCreated},
+}
+
+func TestTools_ServeJSONETag(t *testing.T) {
+	testTools := Tools{JSONETags: true}
+	payload := JSONResponse{Message: "unchanged"}
+
+	// find the ETag the payload gets
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	_ = testTools.ServeJSON(rr, req, http.StatusOK, payload)
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag sent")
+	}
+
+	for _, e := range etagTests {
+		req, _ := http.NewRequest(e.method, "/", nil)
+		switch e.ifNoneMatch {
+		case "match":
+			req.Header.Set("If-None-Match", etag)
+		case "strong":
+			req.Header.Set("If-None-Match", etag[2:])
+		case `"abc", match`:
+			req.Header.Set("If-None-Match", `"abc", `+etag)
+		default:
+			req.Header.Set("If-None-Match", e.ifNoneMatch)
+		}
+
+		rr := httptest.NewRecorder()
+		if err := testTools.ServeJSON(rr, req, e.status, payload); err != nil {
+			t.Errorf("%s: error not expected but one received: %s", e.name, err)
+		}
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectETag != (rr.Header().Get("ETag") == etag) {
+			t.Errorf("%s: wrong ETag %q", e.name, rr.Header().Get("ETag"))
+		}
+		if rr.Code == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: 304 must not have a body", e.name)
+		}
+	}
+}
+
+func TestTools_ServeJSONETagSupplied(t *testing.T) {
+	testTools := Tools{JSONETags: true}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `"v7"`)
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ServeJSON(rr, req, http.StatusOK, JSONResponse{}, http.Header{"Etag": []string{`"v7"`}})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected the supplied ETag to be used, but got %d", rr.Code)
+	}
+}
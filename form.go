@@ -0,0 +1,280 @@
+package toolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// textUnmarshalerType is used to spot fields that parse themselves
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// durationType needs special handling, since it's an int64 underneath
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ReadForm decodes the ordinary fields of an
+// application/x-www-form-urlencoded or multipart/form-data body into
+// data, which must be a pointer to a struct. Fields are matched by their
+// form tag, falling back to the json name, so one struct can serve both
+// kinds of request:
+//
+//	Title string   `form:"title" validate:"required"`
+//	Tags  []string `form:"tag"`
+//
+// Strings, numbers, bools, durations, time.Time (RFC 3339), anything
+// with an UnmarshalText method, and slices of these are supported;
+// nested structs use dotted names such as address.zip. Values that don't
+// convert are reported together as a *ValidationError, and the validate
+// tags are checked after that. File parts are ignored; see
+// UploadFilesWithForm.
+func (t *Tools) ReadForm(r *http.Request, data interface{}) error {
+	if err := t.parseForm(r); err != nil {
+		return err
+	}
+
+	values := r.PostForm
+	if r.MultipartForm != nil {
+		values = r.MultipartForm.Value
+	}
+	return t.LocalizeError(r, t.decodeForm(values, data))
+}
+
+// UploadFilesWithForm is UploadFiles for forms that mix files with
+// ordinary fields. The fields are decoded into data, as ReadForm does,
+// and checked before any file is written, so a bad form leaves nothing
+// on disk. The uploaded files are returned and data is filled in.
+func (t *Tools) UploadFilesWithForm(r *http.Request, dirName string, data interface{}, rename ...bool) ([]*UploadFile, error) {
+	if err := t.ReadForm(r, data); err != nil {
+		return nil, err
+	}
+	// the form is already parsed, so UploadFiles goes straight to the
+	// file parts
+	return t.UploadFiles(r, dirName, rename...)
+}
+
+// parseForm parses either kind of form body
+func (t *Tools) parseForm(r *http.Request) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return unsupportedMediaType("mediatype.required", "a form")
+	}
+
+	// same limit UploadFiles uses
+	maxSize := t.MaxFileSize
+	if maxSize == 0 {
+		maxSize = 1024 * 1024 * 1024
+	}
+	if err := t.verifyForm(r, maxSize); err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "multipart/form-data":
+		return r.ParseMultipartForm(maxSize)
+	case "application/x-www-form-urlencoded":
+		return r.ParseForm()
+	default:
+		return unsupportedMediaType("mediatype.wrong", "a form", mediaType)
+	}
+}
+
+// verifyForm checks the webhook signature of a form body that hasn't
+// been parsed yet, and puts the body back for parsing
+func (t *Tools) verifyForm(r *http.Request, maxSize int64) error {
+	if t.WebhookVerifier == nil || r.Form != nil || r.MultipartForm != nil {
+		return nil
+	}
+	// the whole body is held in memory, so it gets the verifier's limit
+	if limit := t.WebhookVerifier.maxBodySize(); maxSize > limit {
+		maxSize = limit
+	}
+	body, err := t.verifyBody(r, http.MaxBytesReader(nil, r.Body, maxSize), int(maxSize))
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// decodeForm fills data from form values, then validates it
+func (t *Tools) decodeForm(values url.Values, data interface{}) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("form target must be a non-nil pointer to a struct")
+	}
+
+	var ve ValidationError
+	if err := decodeFormStruct(values, v.Elem(), "", &ve); err != nil {
+		return err
+	}
+	if len(ve.Errors) > 0 {
+		return &ve
+	}
+
+	// report problems under the form names, not the json ones
+	return validateWith(v.Elem(), formFieldName)
+}
+
+// decodeFormStruct fills the fields of a struct, using prefix for nested
+// structs
+func decodeFormStruct(values url.Values, v reflect.Value, prefix string, ve *ValidationError) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, ok := formFieldName(sf)
+		if !ok {
+			continue
+		}
+		name = joinPath(prefix, name)
+		field := v.Field(i)
+
+		// nested structs, unless they know how to parse themselves the
+		// way time.Time does
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && !reflect.PtrTo(ft).Implements(textUnmarshalerType) {
+			if field.Kind() == reflect.Ptr {
+				if !hasPrefix(values, name+".") {
+					continue
+				}
+				if field.IsNil() {
+					field.Set(reflect.New(ft))
+				}
+				field = field.Elem()
+			}
+			if err := decodeFormStruct(values, field, name, ve); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := values[name]
+		if !ok || len(raw) == 0 {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(field.Type(), len(raw), len(raw))
+			for j, s := range raw {
+				if reason, err := setFormValue(slice.Index(j), s); err != nil {
+					return fmt.Errorf("field %s: %w", sf.Name, err)
+				} else if reason.code != "" {
+					ve.add(name, "type", reason)
+					break
+				}
+			}
+			field.Set(slice)
+			continue
+		}
+
+		reason, err := setFormValue(field, raw[0])
+		if err != nil {
+			return fmt.Errorf("field %s: %w", sf.Name, err)
+		}
+		if reason.code != "" {
+			ve.add(name, "type", reason)
+		}
+	}
+	return nil
+}
+
+// setFormValue converts s and stores it in field. It returns a reason
+// if s doesn't convert, and an error if the field's type isn't supported
+func setFormValue(field reflect.Value, s string) (message, error) {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setFormValue(field.Elem(), s)
+	}
+
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshalerType) {
+		if err := field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return msg("validation.invalid"), nil
+		}
+		return message{}, nil
+	}
+
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return msg("validation.duration"), nil
+		}
+		field.SetInt(int64(d))
+		return message{}, nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		// an unchecked checkbox isn't sent at all, and a checked one
+		// is usually sent as "on"
+		if s == "on" {
+			field.SetBool(true)
+			return message{}, nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return msg("validation.bool"), nil
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, field.Type().Bits())
+		if err != nil {
+			return msg("validation.whole_number"), nil
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(strings.TrimSpace(s), 10, field.Type().Bits())
+		if err != nil {
+			return msg("validation.non_negative_whole_number"), nil
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), field.Type().Bits())
+		if err != nil {
+			return msg("validation.number"), nil
+		}
+		field.SetFloat(f)
+	default:
+		return message{}, fmt.Errorf("form fields of type %s are not supported", field.Type())
+	}
+	return message{}, nil
+}
+
+// formFieldName returns the form name of a struct field: the form tag,
+// then the json name. False means the field is skipped
+func formFieldName(sf reflect.StructField) (string, bool) {
+	if tag := sf.Tag.Get("form"); tag != "" {
+		if tag == "-" {
+			return "", false
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		return name, true
+	}
+	return jsonFieldName(sf)
+}
+
+// hasPrefix reports whether any form key starts with prefix
+func hasPrefix(values url.Values, prefix string) bool {
+	for k := range values {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
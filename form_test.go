@@ -0,0 +1,174 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type formAddress struct {
+	Zip string `form:"zip" validate:"required"`
+}
+
+type formPost struct {
+	Title    string        `form:"title" validate:"required,min=3"`
+	Count    int           `form:"count"`
+	Price    float64       `json:"price"`
+	Public   bool          `form:"public"`
+	Tags     []string      `form:"tag"`
+	IDs      []int         `form:"id"`
+	TTL      time.Duration `form:"ttl"`
+	Date     time.Time     `form:"date"`
+	Note     *string       `form:"note"`
+	Address  formAddress   `form:"address"`
+	Internal string        `form:"-"`
+}
+
+var formTests = []struct {
+	name           string
+	body           string
+	expectedFields map[string]string
+}{
+	{name: "good", body: "title=Hello&count=3&price=9.5&public=on&tag=a&tag=b&id=1&id=2&ttl=1m&date=2024-01-02T00:00:00Z&note=hi&address.zip=12345&Internal=x"},
+	{name: "bad values", body: "title=Hello&count=three&price=cheap&public=maybe&id=1&id=x&ttl=soon&date=today&address.zip=1", expectedFields: map[string]string{
+		"count":  "must be a whole number",
+		"price":  "must be a number",
+		"public": "must be true or false",
+		"id":     "must be a whole number",
+		"ttl":    "must be a duration such as 90s or 1h30m",
+		"date":   "is not valid",
+	}},
+	{name: "validation", body: "title=Hi", expectedFields: map[string]string{"title": "must have length at least 3", "address.zip": "is required"}},
+}
+
+func TestTools_ReadForm(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range formTests {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		var post formPost
+		err := testTools.ReadForm(req, &post)
+
+		if e.expectedFields == nil {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+				continue
+			}
+			if post.Title != "Hello" || post.Count != 3 || post.Price != 9.5 || !post.Public || strings.Join(post.Tags, ",") != "a,b" ||
+				fmt.Sprint(post.IDs) != "[1 2]" || post.TTL != time.Minute || post.Date.Year() != 2024 || *post.Note != "hi" ||
+				post.Address.Zip != "12345" || post.Internal != "" {
+				t.Errorf("%s: wrong values decoded: %+v", e.name, post)
+			}
+			continue
+		}
+
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%s: expected a validation error but got %v", e.name, err)
+			continue
+		}
+		fields := ve.Fields()
+		if len(fields) != len(e.expectedFields) {
+			t.Errorf("%s: expected %d failing fields but got %v", e.name, len(e.expectedFields), fields)
+		}
+		for k, v := range e.expectedFields {
+			if fields[k] != v {
+				t.Errorf("%s: %s: expected %q but got %q", e.name, k, v, fields[k])
+			}
+		}
+	}
+}
+
+func TestTools_ReadFormContentType(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"title":"Hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var post formPost
+	err := testTools.ReadForm(req, &post)
+
+	var p *Problem
+	if !errors.As(err, &p) || p.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a 415 error but got %v", err)
+	}
+}
+
+// multipartUpload builds a multipart request with the given fields and
+// a copy of testdata/img.png
+func multipartUpload(t *testing.T, fields map[string]string) *http.Request {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		defer pw.Close()
+		defer writer.Close()
+
+		for k, v := range fields {
+			_ = writer.WriteField(k, v)
+		}
+
+		part, err := writer.CreateFormFile("file", "img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		f, err := os.Open("./testdata/img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		_, _ = io.Copy(part, f)
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestTools_UploadFilesWithForm(t *testing.T) {
+	var testTools Tools
+
+	req := multipartUpload(t, map[string]string{"title": "Holiday", "tag": "beach", "address.zip": "12345"})
+
+	var post formPost
+	files, err := testTools.UploadFilesWithForm(req, "./testdata/uploads/", &post, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.Title != "Holiday" || strings.Join(post.Tags, ",") != "beach" {
+		t.Errorf("wrong fields decoded: %+v", post)
+	}
+	if len(files) != 1 || files[0].OriginalFileName != "img.png" {
+		t.Fatalf("wrong files uploaded: %v", files)
+	}
+	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", files[0].NewFileName)); os.IsNotExist(err) {
+		t.Errorf("expected file to exist: %s", err)
+	}
+	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", files[0].NewFileName))
+
+	// a bad form stops before any file is written
+	req = multipartUpload(t, map[string]string{"title": "Holiday"})
+	var badPost formPost
+	files, err = testTools.UploadFilesWithForm(req, "./testdata/uploads/", &badPost, false)
+
+	var ve *ValidationError
+	if !errors.As(err, &ve) || files != nil {
+		t.Errorf("expected a validation error and no files but got %v, %v", err, files)
+	}
+	if _, err := os.Stat("./testdata/uploads/img.png"); !os.IsNotExist(err) {
+		t.Error("file should not have been written")
+		_ = os.Remove("./testdata/uploads/img.png")
+	}
+}
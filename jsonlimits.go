@@ -0,0 +1,283 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// hasJSONLimits reports whether any of the structural limits, or the
+// duplicate key check, are on, so the pre-pass can be skipped when
+// they aren't
+func (t *Tools) hasJSONLimits() bool {
+	return t.MaxJSONDepth > 0 || t.MaxJSONObjectKeys > 0 || t.MaxJSONArrayLen > 0 || t.MaxJSONStringLen > 0 ||
+		t.DisallowDuplicateKeys
+}
+
+// jsonFrame is an object or array the pre-pass is inside of
+type jsonFrame struct {
+	object bool
+	// path is the JSON path of the object or array itself
+	path string
+	// count is the number of keys or elements seen so far
+	count int
+	// key is the key whose value comes next, for objects
+	key string
+	// expectKey is true when the next token of an object is a key
+	expectKey bool
+	// seen holds the keys of an object when duplicates are checked,
+	// or the fields they fill if the object is decoded into a struct
+	seen map[string]bool
+	// typ is the Go type the object or array is decoded into, or nil
+	// if it isn't known, e.g. for interface{}
+	typ reflect.Type
+	// fields are the fields of a struct
+	fields *structFields
+}
+
+// childPath returns the path of the value about to be read in f
+func (f *jsonFrame) childPath() string {
+	if f.object {
+		return joinPath(f.path, f.key)
+	}
+	return fmt.Sprintf("%s[%d]", f.path, f.count-1)
+}
+
+// childType returns the Go type of the value about to be read in f, or
+// nil if it isn't known
+func (f *jsonFrame) childType() reflect.Type {
+	if f.typ == nil {
+		return nil
+	}
+	switch f.typ.Kind() {
+	case reflect.Struct:
+		if f.fields == nil {
+			return nil
+		}
+		_, typ, _ := f.fields.field(f.key)
+		return decodedType(typ)
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return decodedType(f.typ.Elem())
+	}
+	return nil
+}
+
+// decodedType returns the type encoding/json fills for typ: pointers
+// are followed, and types that decode themselves, or interfaces, are
+// unknown
+func decodedType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() == reflect.Interface || reflect.PtrTo(typ).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+	return typ
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// structFields are the json names of a struct's fields, including
+// those of embedded structs
+type structFields struct {
+	// exact maps json names to field types
+	exact map[string]reflect.Type
+	// folded maps folded json names to the first field that folds
+	// that way
+	folded map[string]string
+}
+
+// field returns the json name and type of the field key fills, the way
+// encoding/json matches them: an exact name first, then a folded one.
+// False means no field takes key
+func (s *structFields) field(key string) (string, reflect.Type, bool) {
+	if typ, ok := s.exact[key]; ok {
+		return key, typ, true
+	}
+	if name, ok := s.folded[foldKey(key)]; ok {
+		return name, s.exact[name], true
+	}
+	return "", nil, false
+}
+
+// structFieldsCache holds the structFields of each struct type seen
+var structFieldsCache sync.Map
+
+// fieldsOf returns the structFields of typ, building them once
+func fieldsOf(typ reflect.Type) *structFields {
+	if cached, ok := structFieldsCache.Load(typ); ok {
+		return cached.(*structFields)
+	}
+	fields := &structFields{exact: make(map[string]reflect.Type), folded: make(map[string]string)}
+	fields.add(typ)
+	cached, _ := structFieldsCache.LoadOrStore(typ, fields)
+	return cached.(*structFields)
+}
+
+// add adds the fields of typ
+func (s *structFields) add(typ reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name, ok := jsonFieldName(sf)
+		if !ok {
+			continue
+		}
+		if embedded := decodedType(sf.Type); sf.Anonymous && sf.Tag.Get("json") == "" && embedded != nil && embedded.Kind() == reflect.Struct {
+			s.add(embedded)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		// the outer field wins, as it does for encoding/json
+		if _, ok := s.exact[name]; !ok {
+			s.exact[name] = sf.Type
+		}
+		if folded := foldKey(name); s.folded[folded] == "" {
+			s.folded[folded] = name
+		}
+	}
+}
+
+// checkJSONLimits walks raw token by token, without building any values,
+// and returns a 400 error for the first limit it breaks, or the first
+// duplicate key. target is what raw will be decoded into: keys are only
+// folded for structs, since "a" and "A" are different keys of a map.
+// Malformed JSON is left for the decoder to report
+func (t *Tools) checkJSONLimits(raw []byte, target interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	rootType := decodedType(reflect.TypeOf(target))
+	var stack []*jsonFrame
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			// io.EOF when we're done; anything else is for the
+			// decoder to complain about
+			return nil
+		}
+
+		var parent *jsonFrame
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		// a key in an object
+		if parent != nil && parent.object && parent.expectKey {
+			if delim, ok := tok.(json.Delim); ok && delim == '}' {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			key, _ := tok.(string)
+			parent.count++
+			parent.key = key
+			parent.expectKey = false
+			if t.MaxJSONObjectKeys > 0 && parent.count > t.MaxJSONObjectKeys {
+				return limitError(parent.path, msg("json.too_many_keys", t.MaxJSONObjectKeys))
+			}
+			if t.MaxJSONStringLen > 0 && len(key) > t.MaxJSONStringLen {
+				return limitError(parent.path, msg("json.key_too_long", t.MaxJSONStringLen))
+			}
+			if t.DisallowDuplicateKeys {
+				// encoding/json matches struct fields without regard to
+				// case when no name matches exactly, so "Role" and "role"
+				// can land in the same field
+				filled := key
+				if parent.fields != nil {
+					if name, _, ok := parent.fields.field(key); ok {
+						filled = name
+					}
+				}
+				if parent.seen[filled] {
+					return badRequest("json.duplicate_key", key, joinPath(parent.path, key))
+				}
+				if parent.seen == nil {
+					parent.seen = make(map[string]bool)
+				}
+				parent.seen[filled] = true
+			}
+			continue
+		}
+
+		// a closing delimiter ends the array we're in
+		if delim, ok := tok.(json.Delim); ok && delim == ']' {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		// anything else is a value: count it in its parent
+		path := ""
+		typ := rootType
+		if parent != nil {
+			if parent.object {
+				parent.expectKey = true
+			} else {
+				parent.count++
+				if t.MaxJSONArrayLen > 0 && parent.count > t.MaxJSONArrayLen {
+					return limitError(parent.path, msg("json.array_too_long", t.MaxJSONArrayLen))
+				}
+			}
+			path = parent.childPath()
+			typ = parent.childType()
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			frame := &jsonFrame{object: v == '{', path: path, expectKey: v == '{', typ: typ}
+			if typ != nil && typ.Kind() == reflect.Struct && frame.object {
+				frame.fields = fieldsOf(typ)
+			}
+			stack = append(stack, frame)
+			if t.MaxJSONDepth > 0 && len(stack) > t.MaxJSONDepth {
+				return limitError(path, msg("json.too_deep", t.MaxJSONDepth))
+			}
+		case string:
+			if t.MaxJSONStringLen > 0 && len(v) > t.MaxJSONStringLen {
+				return limitError(path, msg("json.string_too_long", t.MaxJSONStringLen))
+			}
+		}
+	}
+}
+
+// foldKey folds key the way encoding/json does when it matches struct
+// fields, so two keys fold the same exactly when they'd fill the same
+// field. Simple Unicode folding counts, so "ſtatus" matches "status" and
+// the Kelvin sign matches "k"
+func foldKey(key string) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	for _, r := range key {
+		if r < utf8.RuneSelf {
+			if 'a' <= r && r <= 'z' {
+				r -= 'a' - 'A'
+			}
+			b.WriteRune(r)
+			continue
+		}
+		// the smallest rune of its fold set stands for all of them
+		for {
+			next := unicode.SimpleFold(r)
+			if next <= r {
+				r = next
+				break
+			}
+			r = next
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// limitError builds the error for a broken limit, saying where it broke
+func limitError(path string, m message) error {
+	if path == "" {
+		return badRequest(m.code, m.args...)
+	}
+	return badRequest("json.location", m, path)
+}
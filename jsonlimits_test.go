@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var jsonLimitTests = []struct {
+	name          string
+	tools         Tools
+	json          string
+	errorExpected string
+}{
+	{name: "within limits", tools: Tools{MaxJSONDepth: 3, MaxJSONObjectKeys: 2, MaxJSONArrayLen: 3, MaxJSONStringLen: 5}, json: `{"a":[1,2,{"b":"hello"}],"c":"x"}`},
+	{name: "too deep", tools: Tools{MaxJSONDepth: 3}, json: `{"a":[[{"b":1}]]}`, errorExpected: "body must not be nested more than 3 levels deep (at a[0][0])"},
+	{name: "deep arrays", tools: Tools{MaxJSONDepth: 10}, json: strings.Repeat("[", 50) + strings.Repeat("]", 50), errorExpected: "body must not be nested more than 10 levels deep (at [0][0][0][0][0][0][0][0][0][0])"},
+	{name: "too many keys", tools: Tools{MaxJSONObjectKeys: 2}, json: `{"a":{"x":1,"y":2,"z":3}}`, errorExpected: "objects must not have more than 2 keys (at a)"},
+	{name: "too many keys at the top", tools: Tools{MaxJSONObjectKeys: 2}, json: `{"a":1,"b":2,"c":3}`, errorExpected: "objects must not have more than 2 keys"},
+	{name: "array too long", tools: Tools{MaxJSONArrayLen: 3}, json: `{"items":[{"ids":[1,2,3,4]}]}`, errorExpected: "arrays must not have more than 3 elements (at items[0].ids)"},
+	{name: "string too long", tools: Tools{MaxJSONStringLen: 5}, json: `{"a":["ok","too long"]}`, errorExpected: "strings must not be longer than 5 bytes (at a[1])"},
+	{name: "key too long", tools: Tools{MaxJSONStringLen: 5}, json: `{"a":{"much too long":1}}`, errorExpected: "keys must not be longer than 5 bytes (at a)"},
+	{name: "malformed left to the decoder", tools: Tools{MaxJSONDepth: 3}, json: `{"a":`, errorExpected: "body contains badly-formed JSON"},
+}
+
+func TestTools_ReadJsonLimits(t *testing.T) {
+	for i := range jsonLimitTests {
+		// Tools mustn't be copied once used, so each entry's is used
+		// where it is
+		e := &jsonLimitTests[i]
+		testTools := &e.tools
+		testTools.AllowUnknownFields = true
+
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.json))
+		var payload duplicateKeyPayload
+		err := testTools.ReadJson(httptest.NewRecorder(), req, &payload)
+
+		if e.errorExpected == "" {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != e.errorExpected {
+			t.Errorf("%s: expected %q but got %v", e.name, e.errorExpected, err)
+		}
+
+		var p *Problem
+		if e.name != "malformed left to the decoder" && (!errors.As(err, &p) || p.Status != http.StatusBadRequest) {
+			t.Errorf("%s: expected a 400 error but got %v", e.name, err)
+		}
+	}
+}
+
+func TestTools_ReadNDJSONLimits(t *testing.T) {
+	testTools := Tools{MaxJSONArrayLen: 2}
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader("[1,2]\n[1,2,3]\n"))
+	err := ReadNDJSON(&testTools, httptest.NewRecorder(), req, func(line int, record []int) error { return nil })
+
+	var ndjsonError *NDJSONError
+	if !errors.As(err, &ndjsonError) || ndjsonError.Line != 2 || !strings.Contains(err.Error(), "arrays must not have more than 2 elements") {
+		t.Errorf("expected line 2 to break the limit but got %v", err)
+	}
+}
+
+var duplicateKeyTests = []struct {
+	name          string
+	json          string
+	errorExpected string
+}{
+	{name: "no duplicates", json: `{"role":"user","profile":{"role":"admin"},"list":[{"a":1},{"a":2}]}`},
+	{name: "top level", json: `{"role":"user","name":"x","role":"admin"}`, errorExpected: `body contains duplicate key "role" (at role)`},
+	{name: "nested", json: `{"user":{"profile":{"id":1,"id":2}}}`, errorExpected: `body contains duplicate key "id" (at user.profile.id)`},
+	{name: "in an array", json: `{"items":[{"a":1},{"a":1,"a":2}]}`, errorExpected: `body contains duplicate key "a" (at items[1].a)`},
+	{name: "differing case", json: `{"role":"user","Role":"admin"}`, errorExpected: `body contains duplicate key "Role" (at Role)`},
+	{name: "long s", json: `{"status":"ok","ſtatus":"hacked"}`, errorExpected: `body contains duplicate key "ſtatus" (at ſtatus)`},
+	{name: "kelvin sign", json: `{"kind":"a","\u212aind":"b"}`, errorExpected: "body contains duplicate key \"\u212aind\" (at \u212aind)"},
+	{name: "map keys are exact", json: `{"labels":{"a":1,"A":2}}`},
+	{name: "interface keys are exact", json: `{"extra":{"a":1,"A":2}}`},
+	{name: "map duplicate", json: `{"labels":{"a":1,"a":2}}`, errorExpected: `body contains duplicate key "a" (at labels.a)`},
+	{name: "embedded struct", json: `{"Note":"x","note":"y"}`, errorExpected: `body contains duplicate key "note" (at note)`},
+	{name: "exact names", json: `{"mode":"x","MODE":"y"}`},
+	{name: "folded after exact", json: `{"mode":"x","Mode":"y"}`, errorExpected: `body contains duplicate key "Mode" (at Mode)`},
+}
+
+type duplicateKeyNote struct {
+	Note string
+}
+
+type duplicateKeyPayload struct {
+	duplicateKeyNote
+	Role   string `json:"role"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Kind   string `json:"kind"`
+	Mode   string `json:"mode"`
+	MODE   string `json:"MODE"`
+	User   *struct {
+		Profile struct {
+			ID int `json:"id"`
+		} `json:"profile"`
+	} `json:"user"`
+	Items []struct {
+		A int `json:"a"`
+	} `json:"items"`
+	Profile map[string]string `json:"profile"`
+	List    []interface{}     `json:"list"`
+	Labels  map[string]int    `json:"labels"`
+	Extra   interface{}       `json:"extra"`
+}
+
+func TestTools_ReadJsonDuplicateKeys(t *testing.T) {
+	testTools := Tools{DisallowDuplicateKeys: true, AllowUnknownFields: true}
+
+	for _, e := range duplicateKeyTests {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.json))
+		var payload duplicateKeyPayload
+		err := testTools.ReadJson(httptest.NewRecorder(), req, &payload)
+
+		if e.errorExpected == "" {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != e.errorExpected {
+			t.Errorf("%s: expected %q but got %v", e.name, e.errorExpected, err)
+		}
+	}
+
+	// decoded into interface{}, keys differing in case are different keys
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"a":1,"A":2}`))
+	var anything interface{}
+	if err := testTools.ReadJson(httptest.NewRecorder(), req, &anything); err != nil {
+		t.Errorf("expected keys differing in case to be allowed in interface{}, but got %v", err)
+	}
+
+	// without the option the last value wins, as encoding/json does it
+	var lenient Tools
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(`{"role":"user","role":"admin"}`))
+	var payload struct {
+		Role string `json:"role"`
+	}
+	if err := lenient.ReadJson(httptest.NewRecorder(), req, &payload); err != nil || payload.Role != "admin" {
+		t.Errorf("expected the last value without the option, but got %v, %q", err, payload.Role)
+	}
+}
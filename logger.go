@@ -0,0 +1,131 @@
+package toolkit
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// LogLevel is the severity of a log entry
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns DEBUG, INFO, WARN or ERROR
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Logger receives the toolkit's log entries. Each entry has a message
+// and alternating keys and values, for example:
+//
+//	logger.Info("file uploaded", "filename", "cat.png", "size", 2048)
+//
+// The method set matches log/slog's Logger, so a *slog.Logger can be
+// used as it is. Set it on Tools.Logger; by default nothing is logged.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// nopLogger is the silent default
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// logger returns the Logger configured on Tools, wrapped so sensitive
+// values are redacted, or the silent one
+func (t *Tools) logger() Logger {
+	if t.Logger != nil {
+		return redactingLogger{next: t.Logger, rd: t.redactor()}
+	}
+	return nopLogger{}
+}
+
+// StdLogger adapts a standard library *log.Logger to Logger. Entries
+// below Level are dropped, and the rest are written on one line as
+// LEVEL message key=value key=value.
+type StdLogger struct {
+	Logger *log.Logger
+	Level  LogLevel
+}
+
+// NewStdLogger returns a Logger that writes entries of level and above
+// to l, or to the standard logger if l is nil
+func NewStdLogger(l *log.Logger, level LogLevel) *StdLogger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &StdLogger{Logger: l, Level: level}
+}
+
+func (s *StdLogger) Debug(msg string, keyvals ...interface{}) {
+	s.log(3, LevelDebug, msg, keyvals)
+}
+
+func (s *StdLogger) Info(msg string, keyvals ...interface{}) {
+	s.log(3, LevelInfo, msg, keyvals)
+}
+
+func (s *StdLogger) Warn(msg string, keyvals ...interface{}) {
+	s.log(3, LevelWarn, msg, keyvals)
+}
+
+func (s *StdLogger) Error(msg string, keyvals ...interface{}) {
+	s.log(3, LevelError, msg, keyvals)
+}
+
+// log formats and writes one entry. calldepth is passed to Output, so
+// the file and line flags point at whoever logged the entry
+func (s *StdLogger) log(calldepth int, level LogLevel, msg string, keyvals []interface{}) {
+	if level < s.Level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := "!MISSING"
+		if i+1 < len(keyvals) {
+			value = fmt.Sprint(keyvals[i+1])
+		}
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(quoteLogValue(value))
+	}
+
+	_ = s.Logger.Output(calldepth, b.String())
+}
+
+// quoteLogValue quotes values that would otherwise run into the next
+// key
+func quoteLogValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,118 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLogger keeps every entry as a line of text
+type testLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.add("DEBUG", msg, keyvals) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.add("INFO", msg, keyvals) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.add("WARN", msg, keyvals) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.add("ERROR", msg, keyvals) }
+
+func (l *testLogger) add(level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(level, " ", msg, " ", keyvals))
+}
+
+// find returns the first entry containing all of parts
+func (l *testLogger) find(parts ...string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+next:
+	for _, e := range l.entries {
+		for _, p := range parts {
+			if !strings.Contains(e, p) {
+				continue next
+			}
+		}
+		return e, true
+	}
+	return "", false
+}
+
+var stdLoggerTests = []struct {
+	name     string
+	level    LogLevel
+	log      func(l Logger)
+	expected string
+}{
+	{name: "info", level: LevelInfo, log: func(l Logger) { l.Info("file uploaded", "filename", "cat.png", "size", 2048) }, expected: "INFO file uploaded filename=cat.png size=2048\n"},
+	{name: "quoted", level: LevelInfo, log: func(l Logger) { l.Warn("failed", "error", "body must not be empty", "id", "") }, expected: "WARN failed error=\"body must not be empty\" id=\"\"\n"},
+	{name: "odd keyvals", level: LevelInfo, log: func(l Logger) { l.Error("oops", "key") }, expected: "ERROR oops key=!MISSING\n"},
+	{name: "below level", level: LevelInfo, log: func(l Logger) { l.Debug("noise") }, expected: ""},
+}
+
+func TestStdLogger(t *testing.T) {
+	for _, e := range stdLoggerTests {
+		var buf bytes.Buffer
+		logger := NewStdLogger(log.New(&buf, "", 0), e.level)
+
+		e.log(logger)
+		if buf.String() != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, buf.String())
+		}
+	}
+}
+
+func TestStdLogger_Caller(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", log.Lshortfile), LevelDebug)
+
+	// used directly, the caller is the one that logged
+	logger.Info("direct")
+	if !strings.HasPrefix(buf.String(), "logger_test.go:") {
+		t.Errorf("wrong caller for a direct entry: %q", buf.String())
+	}
+
+	// through Tools, it's the toolkit code, not the redacting wrapper
+	buf.Reset()
+	testTools := Tools{Logger: logger, Production: true}
+	_ = testTools.ErrorJSON(httptest.NewRecorder(), errors.New("boom"))
+	if !strings.HasPrefix(buf.String(), "tools.go:") {
+		t.Errorf("wrong caller for a toolkit entry: %q", buf.String())
+	}
+}
+
+func TestTools_Logger(t *testing.T) {
+	logger := &testLogger{}
+	testTools := Tools{Logger: logger}
+
+	// uploads report the file, its size and the request
+	req := multipartUpload(t, map[string]string{})
+	req.Header.Set("X-Request-ID", "req-1")
+	files, err := testTools.UploadFiles(req, "./testdata/uploads/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove("./testdata/uploads/" + files[0].NewFileName)
+
+	if _, ok := logger.find("INFO file uploaded", "request_id req-1", "filename img.png", fmt.Sprintf("size %d", files[0].FileSize), "duration"); !ok {
+		t.Errorf("upload not logged: %v", logger.entries)
+	}
+
+	// bad bodies are reported with the friendly error
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(`{"foo":`))
+	var payload struct{ Foo string }
+	_ = testTools.ReadJson(httptest.NewRecorder(), req, &payload)
+
+	if _, ok := logger.find("WARN reading JSON body failed", "badly-formed JSON"); !ok {
+		t.Errorf("bad body not logged: %v", logger.entries)
+	}
+}
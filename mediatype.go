@@ -0,0 +1,192 @@
+package toolkit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// isJSONMediaType reports whether mediaType is application/json or uses
+// the +json structured syntax suffix, e.g. application/merge-patch+json
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// unsupportedMediaType builds the 415 error returned by ReadJson. code
+// picks the message from the catalogs
+func unsupportedMediaType(code string, args ...interface{}) error {
+	m := msg(code, args...)
+	return &Problem{Status: http.StatusUnsupportedMediaType, Detail: m.String(), message: m}
+}
+
+// jsonBody checks the Content-Type of r and returns a reader for the
+// body that yields UTF-8. If RequireJSONContentType is set, anything
+// other than a JSON media type is rejected with a 415 error. Bodies in a
+// non UTF-8 charset are transcoded when we know the charset, and
+// rejected with a 415 when we don't.
+func (t *Tools) jsonBody(r *http.Request, body io.Reader) (io.Reader, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		if t.RequireJSONContentType {
+			return nil, unsupportedMediaType("mediatype.required", "application/json")
+		}
+		return body, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		if t.RequireJSONContentType {
+			return nil, unsupportedMediaType("mediatype.malformed")
+		}
+		// we didn't ask for a content type, so we don't complain
+		// about a broken one either
+		return body, nil
+	}
+
+	if t.RequireJSONContentType && !isJSONMediaType(mediaType) {
+		return nil, unsupportedMediaType("mediatype.wrong", "application/json", mediaType)
+	}
+
+	return transcodeToUTF8(body, params["charset"])
+}
+
+// transcodeToUTF8 wraps body so it yields UTF-8, based on the declared
+// charset. Only the charsets we can handle with the standard library are
+// supported
+func transcodeToUTF8(body io.Reader, charset string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		// ASCII is a subset of UTF-8, so there's nothing to do
+		return body, nil
+	case "iso-8859-1", "latin1", "latin-1", "l1":
+		return &latin1Reader{r: bufio.NewReader(body)}, nil
+	case "utf-16":
+		// no byte order given, so look for a byte order mark and fall
+		// back to big endian as RFC 2781 says
+		return &utf16Reader{r: bufio.NewReader(body), detectBOM: true}, nil
+	case "utf-16be":
+		return &utf16Reader{r: bufio.NewReader(body)}, nil
+	case "utf-16le":
+		return &utf16Reader{r: bufio.NewReader(body), littleEndian: true}, nil
+	default:
+		return nil, unsupportedMediaType("mediatype.charset", charset)
+	}
+}
+
+// latin1Reader transcodes ISO-8859-1 to UTF-8. Every byte maps to the
+// code point with the same value
+type latin1Reader struct {
+	r       *bufio.Reader
+	pending []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			c := copy(p[n:], l.pending)
+			l.pending = l.pending[c:]
+			n += c
+			continue
+		}
+
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		l.pending = utf8.AppendRune(nil, rune(b))
+	}
+	return n, nil
+}
+
+// utf16Reader transcodes UTF-16 to UTF-8
+type utf16Reader struct {
+	r            *bufio.Reader
+	littleEndian bool
+	detectBOM    bool
+	pending      []byte
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(u.pending) > 0 {
+			c := copy(p[n:], u.pending)
+			u.pending = u.pending[c:]
+			n += c
+			continue
+		}
+
+		r, err := u.readRune()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		u.pending = utf8.AppendRune(nil, r)
+	}
+	return n, nil
+}
+
+// readRune reads one code point, which may be a surrogate pair
+func (u *utf16Reader) readRune() (rune, error) {
+	unit, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+
+	if u.detectBOM {
+		u.detectBOM = false
+		switch unit {
+		case 0xFEFF:
+			return u.readRune()
+		case 0xFFFE:
+			u.littleEndian = true
+			return u.readRune()
+		}
+	}
+
+	if !utf16.IsSurrogate(rune(unit)) {
+		return rune(unit), nil
+	}
+
+	low, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	r := utf16.DecodeRune(rune(unit), rune(low))
+	if r == utf8.RuneError {
+		return 0, badRequest("body.invalid_utf16")
+	}
+	return r, nil
+}
+
+// readUnit reads one 16 bit code unit
+func (u *utf16Reader) readUnit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, badRequest("body.truncated_utf16")
+		}
+		return 0, err
+	}
+	if u.littleEndian {
+		return uint16(b[0]) | uint16(b[1])<<8, nil
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), nil
+}
@@ -0,0 +1,86 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var contentTypeTests = []struct {
+	name           string
+	contentType    string
+	body           []byte
+	require        bool
+	expected       string
+	expectedStatus int // 0 means no error expected
+}{
+	{name: "no content type", contentType: "", body: []byte(`{"foo": "bar"}`), require: false, expected: "bar"},
+	{name: "no content type required", contentType: "", body: []byte(`{"foo": "bar"}`), require: true, expectedStatus: http.StatusUnsupportedMediaType},
+	{name: "json", contentType: "application/json", body: []byte(`{"foo": "bar"}`), require: true, expected: "bar"},
+	{name: "json with utf-8", contentType: "application/json; charset=UTF-8", body: []byte(`{"foo": "bar"}`), require: true, expected: "bar"},
+	{name: "json suffix", contentType: "application/vnd.api+json", body: []byte(`{"foo": "bar"}`), require: true, expected: "bar"},
+	{name: "form post", contentType: "application/x-www-form-urlencoded", body: []byte(`foo=bar`), require: true, expectedStatus: http.StatusUnsupportedMediaType},
+	{name: "text not required", contentType: "text/plain", body: []byte(`{"foo": "bar"}`), require: false, expected: "bar"},
+	{name: "malformed content type", contentType: "application/json; charset", body: []byte(`{"foo": "bar"}`), require: true, expectedStatus: http.StatusUnsupportedMediaType},
+	{name: "latin1", contentType: "application/json; charset=ISO-8859-1", body: []byte("{\"foo\": \"caf\xe9\"}"), require: true, expected: "café"},
+	{name: "utf-16le", contentType: "application/json; charset=utf-16le", body: utf16LE(`{"foo": "café 😀"}`), require: true, expected: "café 😀"},
+	{name: "utf-16 with bom", contentType: "application/json; charset=utf-16", body: append([]byte{0xFF, 0xFE}, utf16LE(`{"foo": "café"}`)...), require: true, expected: "café"},
+	{name: "unknown charset", contentType: "application/json; charset=koi8-r", body: []byte(`{"foo": "bar"}`), require: false, expectedStatus: http.StatusUnsupportedMediaType},
+}
+
+// utf16LE encodes s as little endian UTF-16
+func utf16LE(s string) []byte {
+	var out []byte
+	for _, r := range s {
+		if r >= 0x10000 {
+			r -= 0x10000
+			hi, lo := 0xD800+(r>>10), 0xDC00+(r&0x3FF)
+			out = append(out, byte(hi), byte(hi>>8), byte(lo), byte(lo>>8))
+			continue
+		}
+		out = append(out, byte(r), byte(r>>8))
+	}
+	return out
+}
+
+func TestTools_ReadJSONContentType(t *testing.T) {
+	for _, e := range contentTypeTests {
+		testTools := Tools{RequireJSONContentType: e.require}
+
+		var decoded struct {
+			Foo string `json:"foo"`
+		}
+
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(e.body))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+		rr := httptest.NewRecorder()
+
+		err := testTools.ReadJson(rr, req, &decoded)
+		if e.expectedStatus == 0 {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err.Error())
+			}
+			if decoded.Foo != e.expected {
+				t.Errorf("%s: expected %q but got %q", e.name, e.expected, decoded.Foo)
+			}
+			continue
+		}
+
+		var p *Problem
+		if !errors.As(err, &p) || p.Status != e.expectedStatus {
+			t.Errorf("%s: expected a %d error but got %v", e.name, e.expectedStatus, err)
+			continue
+		}
+
+		// and make sure ErrorJSON sends the right status
+		rr = httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status code returned; expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
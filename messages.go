@@ -0,0 +1,324 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Catalog maps stable message codes, such as "json.empty", to fmt
+// formats in one language. The English catalog, EnglishMessages, lists
+// every code and the arguments each one gets.
+type Catalog map[string]string
+
+// EnglishMessages is the built in catalog, and the fallback for any code
+// another catalog leaves out
+var EnglishMessages = Catalog{
+	// reading bodies
+	"json.syntax":            "body contains badly-formed JSON (at character %v)",
+	"json.malformed":         "body contains badly-formed JSON",
+	"json.field_type":        "body contains incorrect JSON type for field %q",
+	"json.type":              "body contains incorrect JSON type (at character %v)",
+	"json.empty":             "body must not be empty",
+	"json.unknown_key":       "body contains unknown key %v",
+	"json.too_large":         "body must not be larger than %v bytes",
+	"json.multiple_values":   "body must contain only one JSON value",
+	"json.too_deep":          "body must not be nested more than %v levels deep",
+	"json.too_many_keys":     "objects must not have more than %v keys",
+	"json.array_too_long":    "arrays must not have more than %v elements",
+	"json.string_too_long":   "strings must not be longer than %v bytes",
+	"json.key_too_long":      "keys must not be longer than %v bytes",
+	"json.location":          "%v (at %v)",
+	"json.duplicate_key":     "body contains duplicate key %q (at %v)",
+	"body.malformed":         "body contains badly-formed %v: %v",
+	"body.invalid_utf16":     "body contains invalid UTF-16",
+	"body.truncated_utf16":   "body contains truncated UTF-16",
+	"body.bad_encoding":      "body is not valid %v data",
+	"ndjson.multiple_values": "record must contain only one JSON value",
+	"ndjson.too_large":       "record must not be larger than %v bytes",
+
+	// content types
+	"mediatype.required":    "Content-Type header must be %v",
+	"mediatype.malformed":   "Content-Type header is malformed",
+	"mediatype.wrong":       "Content-Type header must be %v, not %v",
+	"mediatype.unsupported": "Content-Type %v is not supported",
+	"mediatype.charset":     "charset %q is not supported",
+	"mediatype.encoding":    "Content-Encoding %q is not supported",
+
+	// validation
+	"validation.failed":                    "validation failed",
+	"validation.title":                     "Validation failed",
+	"validation.required":                  "is required",
+	"validation.min_length":                "must have length at least %v",
+	"validation.max_length":                "must have length at most %v",
+	"validation.len_length":                "must have length exactly %v",
+	"validation.min":                       "must be at least %v",
+	"validation.max":                       "must be at most %v",
+	"validation.len":                       "must be exactly %v",
+	"validation.greater_than":              "must be greater than %v",
+	"validation.less_than":                 "must be less than %v",
+	"validation.range":                     "must be between %v and %v",
+	"validation.email":                     "must be a valid email address",
+	"validation.oneof":                     "must be one of: %v",
+	"validation.whole_number":              "must be a whole number",
+	"validation.non_negative_whole_number": "must be a whole number that isn't negative",
+	"validation.number":                    "must be a number",
+	"validation.number_bounds":             "must not have more than %v digits or an exponent beyond ±%v",
+	"validation.bool":                      "must be true or false",
+	"validation.duration":                  "must be a duration such as 90s or 1h30m",
+	"validation.time_format":               "must be a time in the format %v",
+	"validation.invalid":                   "is not valid",
+	"validation.exclusive":                 "cannot be used with %v",
+	"validation.not_allowed":               "is not allowed",
+	"validation.not_allowed_property":      "is not an allowed property",
+	"validation.type":                      "must be of type %v",
+	"validation.const":                     "must be %v",
+	"validation.min_items":                 "must have at least %v items",
+	"validation.max_items":                 "must have at most %v items",
+	"validation.pattern":                   "must match the pattern %v",
+}
+
+// message is a localizable text: a catalog code and its arguments.
+// Arguments that are messages themselves are translated too
+type message struct {
+	code string
+	args []interface{}
+}
+
+// msg builds a message
+func msg(code string, args ...interface{}) message {
+	return message{code: code, args: args}
+}
+
+// String returns the message in English
+func (m message) String() string {
+	return defaultCatalogs.text("en", m)
+}
+
+// MessageCatalogs holds a catalog per language. Languages are BCP 47
+// tags such as "fr" or "pt-BR", compared without regard to case.
+type MessageCatalogs struct {
+	mu    sync.RWMutex
+	langs map[string]Catalog
+}
+
+// NewMessageCatalogs returns a registry holding the English catalog
+func NewMessageCatalogs() *MessageCatalogs {
+	mc := &MessageCatalogs{}
+	mc.Register("en", EnglishMessages)
+	return mc
+}
+
+// Register adds the messages in c to the catalog for lang. Codes that are
+// already there are replaced, so a catalog can be registered in parts,
+// or English messages reworded
+func (mc *MessageCatalogs) Register(lang string, c Catalog) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	lang = strings.ToLower(lang)
+	if mc.langs == nil {
+		mc.langs = make(map[string]Catalog)
+	}
+	merged := make(Catalog, len(mc.langs[lang])+len(c))
+	for code, format := range mc.langs[lang] {
+		merged[code] = format
+	}
+	for code, format := range c {
+		merged[code] = format
+	}
+	mc.langs[lang] = merged
+}
+
+// has reports whether there's a catalog for lang
+func (mc *MessageCatalogs) has(lang string) bool {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	_, ok := mc.langs[strings.ToLower(lang)]
+	return ok
+}
+
+// text renders m in lang, falling back to English for codes lang's
+// catalog doesn't have
+func (mc *MessageCatalogs) text(lang string, m message) string {
+	args := make([]interface{}, len(m.args))
+	for i, arg := range m.args {
+		if inner, ok := arg.(message); ok {
+			arg = mc.text(lang, inner)
+		}
+		args[i] = arg
+	}
+
+	mc.mu.RLock()
+	format, ok := mc.langs[strings.ToLower(lang)][m.code]
+	if !ok {
+		format, ok = mc.langs["en"][m.code]
+	}
+	mc.mu.RUnlock()
+
+	if !ok {
+		format = EnglishMessages[m.code]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+var defaultCatalogs = NewMessageCatalogs()
+
+// RegisterCatalog adds the messages in c to the package's default
+// catalogs for lang, which every Tools without its own Catalogs uses
+func RegisterCatalog(lang string, c Catalog) {
+	defaultCatalogs.Register(lang, c)
+}
+
+// catalogs returns the registry configured on Tools, or the default one
+func (t *Tools) catalogs() *MessageCatalogs {
+	if t.Catalogs != nil {
+		return t.Catalogs
+	}
+	return defaultCatalogs
+}
+
+// languageKey is the context key for the language
+type languageKey struct{}
+
+// WithLanguage returns a copy of ctx asking for messages in lang. It
+// takes priority over the request's Accept-Language header, e.g. for a
+// language stored in the user's profile.
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// LanguageFromContext returns the language stored by WithLanguage, or an
+// empty string
+func LanguageFromContext(ctx context.Context) string {
+	lang, _ := ctx.Value(languageKey{}).(string)
+	return lang
+}
+
+// Language picks the language for messages to r: the one in its context,
+// then the best match in its Accept-Language header that has a catalog,
+// then DefaultLanguage, then English. A tag with no catalog of its own,
+// such as fr-CA, falls back to its base language, fr.
+func (t *Tools) Language(r *http.Request) string {
+	mc := t.catalogs()
+	match := func(lang string) string {
+		if mc.has(lang) {
+			return strings.ToLower(lang)
+		}
+		if base, _, ok := strings.Cut(lang, "-"); ok && mc.has(base) {
+			return strings.ToLower(base)
+		}
+		return ""
+	}
+
+	if r != nil {
+		if lang := match(LanguageFromContext(r.Context())); lang != "" {
+			return lang
+		}
+
+		type languageRange struct {
+			tag string
+			q   float64
+		}
+		var ranges []languageRange
+		for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+			tag, q := parseQuality(part)
+			if tag != "" && tag != "*" && q > 0 {
+				ranges = append(ranges, languageRange{tag: tag, q: q})
+			}
+		}
+		sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+		for _, lr := range ranges {
+			if lang := match(lr.tag); lang != "" {
+				return lang
+			}
+		}
+	}
+
+	if lang := match(t.DefaultLanguage); lang != "" {
+		return lang
+	}
+	return "en"
+}
+
+// LocalizeError returns err with its messages in the language Language
+// picks for r. ReadJson, ReadNDJSON, ReadForm and Params.Err already do
+// this; use it for validation errors from elsewhere, e.g. Validate.
+// Errors with nothing to translate are returned as they are.
+func (t *Tools) LocalizeError(r *http.Request, err error) error {
+	if err == nil {
+		return nil
+	}
+	return t.localize(t.Language(r), err)
+}
+
+// localize rebuilds the toolkit's own errors with their text in lang
+func (t *Tools) localize(lang string, err error) error {
+	mc := t.catalogs()
+
+	switch e := err.(type) {
+	case *Problem:
+		if e.message.code == "" {
+			return err
+		}
+		p := *e
+		p.Detail = mc.text(lang, e.message)
+		return &p
+
+	case *ValidationError:
+		ve := ValidationError{
+			summary: mc.text(lang, msg("validation.failed")),
+			title:   mc.text(lang, msg("validation.title")),
+		}
+		for _, fe := range e.Errors {
+			if fe.message.code != "" {
+				fe.Reason = mc.text(lang, fe.message)
+			}
+			ve.Errors = append(ve.Errors, fe)
+		}
+		return &ve
+
+	case *NDJSONError:
+		return &NDJSONError{Line: e.Line, Err: t.localize(lang, e.Err)}
+	}
+
+	// a wrapped error is translated where it's wrapped, and the wrapper
+	// kept, so errors.Is and errors.As still see the whole chain
+	for inner := errors.Unwrap(err); inner != nil; inner = errors.Unwrap(inner) {
+		switch inner.(type) {
+		case *Problem, *ValidationError, *NDJSONError:
+			localized := t.localize(lang, inner)
+			if localized == inner {
+				return err
+			}
+			return &localizedError{err: err, inner: inner, localized: localized}
+		}
+	}
+	return err
+}
+
+// localizedError is a wrapped error whose toolkit error was translated.
+// The translation comes first in the chain, so errors.As finds it before
+// the original
+type localizedError struct {
+	err       error
+	inner     error
+	localized error
+}
+
+// Error is the wrapper's message, with the translated text in place of
+// the original
+func (l *localizedError) Error() string {
+	return strings.Replace(l.err.Error(), l.inner.Error(), l.localized.Error(), 1)
+}
+
+// Unwrap returns the translated error, then the original chain
+func (l *localizedError) Unwrap() []error {
+	return []error{l.localized, l.err}
+}
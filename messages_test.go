@@ -0,0 +1,209 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var french = Catalog{
+	"json.empty":          "le corps ne doit pas être vide",
+	"json.too_deep":       "le corps ne doit pas dépasser %v niveaux",
+	"json.location":       "%v (à %v)",
+	"validation.failed":   "validation échouée",
+	"validation.title":    "Validation échouée",
+	"mediatype.malformed": "l'en-tête Content-Type est mal formé",
+	"validation.required": "est obligatoire",
+	"validation.min":      "doit être au moins %v",
+}
+
+func frenchTools() Tools {
+	catalogs := NewMessageCatalogs()
+	catalogs.Register("fr", french)
+	return Tools{Catalogs: catalogs}
+}
+
+var languageTests = []struct {
+	name           string
+	acceptLanguage string
+	context        string
+	defaultLang    string
+	expected       string
+}{
+	{name: "no preference", expected: "en"},
+	{name: "exact", acceptLanguage: "fr", expected: "fr"},
+	{name: "base language", acceptLanguage: "fr-CA", expected: "fr"},
+	{name: "by quality", acceptLanguage: "de;q=0.9, fr;q=0.8, en;q=0.5", expected: "fr"},
+	{name: "english preferred", acceptLanguage: "en-GB, fr;q=0.5", expected: "en"},
+	{name: "refused", acceptLanguage: "fr;q=0", expected: "en"},
+	{name: "nothing known", acceptLanguage: "de, ja", expected: "en"},
+	{name: "default", acceptLanguage: "de", defaultLang: "fr", expected: "fr"},
+	{name: "context wins", acceptLanguage: "en", context: "fr", expected: "fr"},
+	{name: "unknown context", acceptLanguage: "fr", context: "de", expected: "fr"},
+}
+
+func TestTools_Language(t *testing.T) {
+	for _, e := range languageTests {
+		testTools := frenchTools()
+		testTools.DefaultLanguage = e.defaultLang
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		if e.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", e.acceptLanguage)
+		}
+		if e.context != "" {
+			req = req.WithContext(WithLanguage(req.Context(), e.context))
+		}
+
+		if got := testTools.Language(req); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+var localizedReadJsonTests = []struct {
+	name     string
+	tools    Tools
+	json     string
+	expected string
+}{
+	{name: "translated", json: ``, expected: "le corps ne doit pas être vide"},
+	{name: "falls back to english", json: `{"name":`, expected: "body contains badly-formed JSON"},
+	{name: "nested message", tools: Tools{MaxJSONDepth: 1}, json: `{"name":"x","tags":[]}`, expected: "le corps ne doit pas dépasser 1 niveaux (à tags)"},
+	{name: "validation", json: `{"age":3}`, expected: "validation échouée: name: est obligatoire; age: doit être au moins 18"},
+}
+
+func TestTools_ReadJsonLocalized(t *testing.T) {
+	for i := range localizedReadJsonTests {
+		e := &localizedReadJsonTests[i]
+		testTools := &e.tools
+		testTools.Catalogs = frenchTools().Catalogs
+		testTools.AllowUnknownFields = true
+
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.json))
+		req.Header.Set("Accept-Language", "fr-FR, en;q=0.5")
+		var payload struct {
+			Name string `json:"name" validate:"required"`
+			Age  int    `json:"age" validate:"min=18"`
+		}
+		err := testTools.ReadJson(httptest.NewRecorder(), req, &payload)
+		if err == nil || err.Error() != e.expected {
+			t.Errorf("%s: expected %q but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestTools_LocalizeError(t *testing.T) {
+	testTools := frenchTools()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = req.WithContext(WithLanguage(req.Context(), "fr"))
+
+	// the original stays in English, and keeps its status
+	original := badRequest("json.empty")
+	localized := testTools.LocalizeError(req, original)
+	var p *Problem
+	if !errors.As(localized, &p) || p.Status != http.StatusBadRequest || p.Detail != "le corps ne doit pas être vide" {
+		t.Errorf("wrong localized problem: %v", localized)
+	}
+	if original.Error() != "body must not be empty" {
+		t.Errorf("original changed: %v", original)
+	}
+
+	// field reasons are translated, fields and rules are not
+	var payload struct {
+		Name string `json:"name" validate:"required"`
+	}
+	err := testTools.LocalizeError(req, testTools.Validate(&payload))
+	var ve *ValidationError
+	if !errors.As(err, &ve) || ve.Errors[0].Field != "name" || ve.Errors[0].Rule != "required" || ve.Errors[0].Reason != "est obligatoire" {
+		t.Errorf("wrong localized validation error: %v", err)
+	}
+
+	// NDJSON errors keep their line
+	err = testTools.LocalizeError(req, &NDJSONError{Line: 3, Err: badRequest("json.empty")})
+	if err.Error() != "line 3: le corps ne doit pas être vide" {
+		t.Errorf("wrong localized NDJSON error: %v", err)
+	}
+
+	// wrapped errors are translated, and keep their wrapper
+	wrapped := fmt.Errorf("reading order: %w", badRequest("json.empty"))
+	err = testTools.LocalizeError(req, wrapped)
+	if !errors.As(err, &p) || p.Detail != "le corps ne doit pas être vide" {
+		t.Errorf("wrong localized wrapped problem: %v", err)
+	}
+	if err.Error() != "reading order: le corps ne doit pas être vide" || !errors.Is(err, wrapped) {
+		t.Errorf("wrong wrapper: %v", err)
+	}
+
+	// anything else is left alone
+	other := errors.New("boom")
+	if testTools.LocalizeError(req, other) != other {
+		t.Error("expected other errors to be returned as they are")
+	}
+}
+
+func TestTools_ReadBodyLocalized(t *testing.T) {
+	testTools := frenchTools()
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`<a/>`))
+	req.Header.Set("Content-Type", "text/xml; charset")
+	req.Header.Set("Accept-Language", "fr")
+	var p *Problem
+	err := testTools.ReadBody(httptest.NewRecorder(), req, &struct{}{})
+	if !errors.As(err, &p) || p.Status != http.StatusUnsupportedMediaType || p.Detail != "l'en-tête Content-Type est mal formé" {
+		t.Errorf("expected a translated 415 but got %v", err)
+	}
+
+	schema := MustCompileSchema([]byte(`{"type":"object","required":["name"]}`))
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Accept-Language", "fr")
+	err = testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, schema, nil)
+	var ve *ValidationError
+	if !errors.As(err, &ve) || !strings.HasPrefix(err.Error(), "validation échouée: ") || ve.ProblemDetails().Title != "Validation échouée" {
+		t.Errorf("expected a translated schema error but got %v", err)
+	}
+}
+
+func TestTools_ParamsLocalized(t *testing.T) {
+	testTools := frenchTools()
+	req, _ := http.NewRequest("GET", "/?page=0", nil)
+	req.Header.Set("Accept-Language", "fr")
+
+	_, err := testTools.ReadPagination(req)
+	var ve *ValidationError
+	if !errors.As(err, &ve) || ve.Fields()["page"] != "doit être au moins 1" {
+		t.Errorf("expected a translated pagination error but got %v", err)
+	}
+
+	p := testTools.Params(req)
+	p.Require("q")
+	if err := p.Err(); err == nil || err.Error() != "validation échouée: q: est obligatoire" {
+		t.Errorf("expected a translated params error but got %v", err)
+	}
+}
+
+func TestRegisterCatalog(t *testing.T) {
+	// the default catalogs are shared, so put them back afterwards
+	saved := defaultCatalogs
+	defaultCatalogs = NewMessageCatalogs()
+	t.Cleanup(func() { defaultCatalogs = saved })
+
+	RegisterCatalog("x-test", Catalog{"json.empty": "first"})
+	RegisterCatalog("x-test", Catalog{"validation.required": "second"})
+
+	var testTools Tools
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "x-test")
+
+	if err := testTools.LocalizeError(req, badRequest("json.empty")); err.Error() != "first" {
+		t.Errorf("expected the registered message but got %v", err)
+	}
+	var ve ValidationError
+	ve.add("name", "required", msg("validation.required"))
+	if err := testTools.LocalizeError(req, &ve); !strings.HasSuffix(err.Error(), "name: second") {
+		t.Errorf("expected catalogs to merge but got %v", err)
+	}
+}
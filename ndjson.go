@@ -0,0 +1,206 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// NDJSONError reports a problem with one line of a newline-delimited
+// JSON body
+type NDJSONError struct {
+	// Line is the 1-based line number of the record
+	Line int
+	Err  error
+}
+
+// Error implements the error interface
+func (e *NDJSONError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *NDJSONError) Unwrap() error {
+	return e.Err
+}
+
+// ProblemDetails is the underlying error's problem, with the line number
+// in front of its detail. It's nil if the underlying error doesn't
+// describe itself
+func (e *NDJSONError) ProblemDetails() *Problem {
+	var detailer ProblemDetailer
+	if !errors.As(e.Err, &detailer) {
+		return nil
+	}
+	inner := detailer.ProblemDetails()
+	if inner == nil {
+		return nil
+	}
+	p := *inner
+	p.Detail = fmt.Sprintf("line %d: %s", e.Line, inner.Error())
+	return &p
+}
+
+// isNDJSONMediaType reports whether mediaType is one of the names used
+// for newline-delimited JSON. Plain JSON types are accepted too, since a
+// single value is a valid stream of one record
+func isNDJSONMediaType(mediaType string) bool {
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return true
+	}
+	return isJSONMediaType(mediaType)
+}
+
+// ReadNDJSON reads a newline-delimited JSON body one record at a time,
+// decoding each into a T and passing it to fn along with its line
+// number. Blank lines are skipped. Each record is limited to MaxJSONSize
+// bytes and the whole body to MaxNDJSONSize. Records are decoded with
+// the same rules and error messages as ReadJson, including
+// AllowUnknownFields and validate tags.
+//
+// Reading stops at the first bad record, or the first error returned by
+// fn. Either way the error is an *NDJSONError holding the line number.
+func ReadNDJSON[T any](t *Tools, w http.ResponseWriter, r *http.Request, fn func(line int, record T) error) (err error) {
+	start := time.Now()
+	records := 0
+	defer func() {
+		if err != nil {
+			t.logger().Warn("reading NDJSON body failed", "request_id", requestID(r), "records", records,
+				"duration", time.Since(start), "error", err)
+			err = t.LocalizeError(r, err)
+			return
+		}
+		t.logger().Debug("read NDJSON body", "request_id", requestID(r), "records", records, "duration", time.Since(start))
+	}()
+
+	maxRecord := t.maxJSONBytes()
+	maxBytes := 100 * 1024 * 1024 // 100MiB
+	if t.MaxNDJSONSize != 0 {
+		maxBytes = t.MaxNDJSONSize
+	}
+
+	body, err := t.openBody(w, r, maxBytes)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var charset string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		switch {
+		case err != nil && t.RequireJSONContentType:
+			return unsupportedMediaType("mediatype.malformed")
+		case err == nil && t.RequireJSONContentType && !isNDJSONMediaType(mediaType):
+			return unsupportedMediaType("mediatype.wrong", "application/x-ndjson", mediaType)
+		}
+		charset = params["charset"]
+	} else if t.RequireJSONContentType {
+		return unsupportedMediaType("mediatype.required", "application/x-ndjson")
+	}
+
+	decoded, err := transcodeToUTF8(body, charset)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(decoded)
+	for line := 1; ; line++ {
+		raw, err := readLine(reader, maxRecord)
+		if errors.Is(err, io.EOF) && len(raw) == 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return &NDJSONError{Line: line, Err: recordReadError(err, maxRecord, maxBytes)}
+		}
+
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+
+		var record T
+		if err := t.decodeValue(raw, &record, maxRecord); err != nil {
+			return &NDJSONError{Line: line, Err: err}
+		}
+		if err := fn(line, record); err != nil {
+			return &NDJSONError{Line: line, Err: err}
+		}
+		records++
+	}
+}
+
+// errRecordTooLarge is returned by readLine when a line is over the
+// per-record limit
+var errRecordTooLarge = errors.New("record too large")
+
+// readLine reads up to the next newline, without it, refusing lines
+// longer than limit
+func readLine(reader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(bytes.TrimRight(line, "\r\n")) > limit {
+			return nil, errRecordTooLarge
+		}
+
+		switch {
+		case err == nil:
+			return bytes.TrimRight(line, "\r\n"), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return line, err
+		}
+	}
+}
+
+// recordReadError turns an error reading a line into a client message
+func recordReadError(err error, maxRecord, maxBytes int) error {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, errRecordTooLarge):
+		return badRequest("ndjson.too_large", maxRecord)
+	case errors.As(err, &maxBytesError):
+		return badRequest("json.too_large", maxBytes)
+	default:
+		return err
+	}
+}
+
+// decodeValue decodes a single JSON value, such as an NDJSON record, into
+// data with the same rules and messages as ReadJson
+func (t *Tools) decodeValue(raw []byte, data interface{}, maxRecord int) error {
+	if t.hasJSONLimits() {
+		if err := t.checkJSONLimits(raw, data); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if !t.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if t.UseNumber {
+		dec.UseNumber()
+	}
+
+	if err := dec.Decode(data); err != nil {
+		return decodeError(err, data, maxRecord)
+	}
+
+	// one value per line, so anything else after it is an error
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return badRequest("ndjson.multiple_values")
+	}
+
+	return t.Validate(data)
+}
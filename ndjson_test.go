@@ -0,0 +1,138 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ndjsonEvent struct {
+	Name string `json:"name" validate:"required"`
+}
+
+var ndjsonTests = []struct {
+	name          string
+	body          string
+	maxSize       int
+	maxTotal      int
+	allowUnknown  bool
+	expected      []string
+	errorLine     int // 0 means no error expected
+	errorContains string
+}{
+	{name: "good", body: "{\"name\":\"a\"}\n{\"name\":\"b\"}\n", expected: []string{"a", "b"}},
+	{name: "no trailing newline", body: "{\"name\":\"a\"}\n{\"name\":\"b\"}", expected: []string{"a", "b"}},
+	{name: "blank lines and crlf", body: "{\"name\":\"a\"}\r\n\r\n{\"name\":\"b\"}\r\n", expected: []string{"a", "b"}},
+	{name: "empty body", body: "", expected: nil},
+	{name: "bad record", body: "{\"name\":\"a\"}\n{\"name\": }\n{\"name\":\"c\"}\n", expected: []string{"a"}, errorLine: 2, errorContains: "badly-formed JSON"},
+	{name: "two values on a line", body: "{\"name\":\"a\"} {\"name\":\"b\"}\n", errorLine: 1, errorContains: "only one JSON value"},
+	{name: "unknown field", body: "{\"name\":\"a\"}\n{\"name\":\"b\",\"x\":1}\n", expected: []string{"a"}, errorLine: 2, errorContains: "unknown key"},
+	{name: "unknown field allowed", body: "{\"name\":\"a\",\"x\":1}\n", allowUnknown: true, expected: []string{"a"}},
+	{name: "validation", body: "{\"name\":\"a\"}\n{}\n", expected: []string{"a"}, errorLine: 2, errorContains: "is required"},
+	{name: "record too large", body: "{\"name\":\"a\"}\n{\"name\":\"" + strings.Repeat("b", 100) + "\"}\n", maxSize: 50, expected: []string{"a"}, errorLine: 2, errorContains: "record must not be larger than 50 bytes"},
+	{name: "body too large", body: strings.Repeat("{\"name\":\"a\"}\n", 10), maxTotal: 40, expected: []string{"a", "a", "a"}, errorLine: 4, errorContains: "body must not be larger than 40 bytes"},
+}
+
+func TestTools_ReadNDJSON(t *testing.T) {
+	for _, e := range ndjsonTests {
+		testTools := Tools{MaxJSONSize: e.maxSize, MaxNDJSONSize: e.maxTotal, AllowUnknownFields: e.allowUnknown}
+
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+
+		var got []string
+		err := ReadNDJSON(&testTools, httptest.NewRecorder(), req, func(line int, ev ndjsonEvent) error {
+			got = append(got, ev.Name)
+			return nil
+		})
+
+		if strings.Join(got, ",") != strings.Join(e.expected, ",") {
+			t.Errorf("%s: expected records %v but got %v", e.name, e.expected, got)
+		}
+
+		if e.errorLine == 0 {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			}
+			continue
+		}
+
+		var ne *NDJSONError
+		if !errors.As(err, &ne) {
+			t.Errorf("%s: expected an NDJSONError but got %v", e.name, err)
+			continue
+		}
+		if ne.Line != e.errorLine {
+			t.Errorf("%s: expected error on line %d but got line %d", e.name, e.errorLine, ne.Line)
+		}
+		if !strings.Contains(err.Error(), e.errorContains) {
+			t.Errorf("%s: expected error containing %q but got %q", e.name, e.errorContains, err.Error())
+		}
+	}
+}
+
+func TestTools_ReadNDJSONCallbackError(t *testing.T) {
+	var testTools Tools
+
+	stop := errors.New("stop")
+	req, _ := http.NewRequest("POST", "/", strings.NewReader("{\"name\":\"a\"}\n{\"name\":\"b\"}\n"))
+
+	err := ReadNDJSON(&testTools, httptest.NewRecorder(), req, func(line int, ev ndjsonEvent) error {
+		if line == 2 {
+			return stop
+		}
+		return nil
+	})
+
+	if !errors.Is(err, stop) {
+		t.Errorf("expected the callback's error but got %v", err)
+	}
+}
+
+func TestTools_ReadNDJSONContentType(t *testing.T) {
+	testTools := Tools{RequireJSONContentType: true}
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader("{\"name\":\"a\"}\n"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	err := ReadNDJSON(&testTools, httptest.NewRecorder(), req, func(line int, ev ndjsonEvent) error { return nil })
+
+	var p *Problem
+	if !errors.As(err, &p) || p.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a 415 error but got %v", err)
+	}
+}
+
+var ndjsonProblemTests = []struct {
+	name           string
+	err            error
+	expectedStatus int
+	expectedDetail string
+}{
+	{name: "bad record", err: &NDJSONError{Line: 3, Err: badRequest("json.empty")}, expectedStatus: http.StatusBadRequest, expectedDetail: "line 3: body must not be empty"},
+	{name: "invalid record", err: &NDJSONError{Line: 2, Err: &ValidationError{Errors: []FieldError{{Field: "name", Rule: "required", Reason: "is required"}}}}, expectedStatus: http.StatusUnprocessableEntity, expectedDetail: "line 2: validation failed: name: is required"},
+	{name: "too large", err: &NDJSONError{Line: 1, Err: &Problem{Status: http.StatusRequestEntityTooLarge}}, expectedStatus: http.StatusRequestEntityTooLarge, expectedDetail: "line 1: Request Entity Too Large"},
+}
+
+func TestNDJSONError_ProblemDetails(t *testing.T) {
+	testTools := Tools{ProblemJSON: true}
+
+	for _, e := range ndjsonProblemTests {
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, e.err)
+
+		var p Problem
+		_ = json.Unmarshal(rr.Body.Bytes(), &p)
+		if rr.Code != e.expectedStatus || p.Detail != e.expectedDetail {
+			t.Errorf("%s: expected %d %q but got %d %q", e.name, e.expectedStatus, e.expectedDetail, rr.Code, p.Detail)
+		}
+	}
+
+	// the callback's own errors aren't problems
+	if p := (&NDJSONError{Line: 1, Err: errors.New("stop")}).ProblemDetails(); p != nil {
+		t.Errorf("expected no problem but got %v", p)
+	}
+}
@@ -0,0 +1,342 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Encoder turns values into a wire format and back. WriteResponse and
+// ReadBody pick one from an EncoderRegistry using the Accept and
+// Content-Type headers.
+type Encoder interface {
+	// ContentType is the media type the encoder handles, e.g.
+	// application/xml
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONEncoder is the built in application/json Encoder
+type JSONEncoder struct{}
+
+// ContentType implements Encoder
+func (JSONEncoder) ContentType() string { return "application/json" }
+
+// Marshal implements Encoder
+func (JSONEncoder) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Encoder
+func (JSONEncoder) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// XMLEncoder is the built in application/xml Encoder
+type XMLEncoder struct{}
+
+// ContentType implements Encoder
+func (XMLEncoder) ContentType() string { return "application/xml" }
+
+// Marshal implements Encoder. The XML declaration is included
+func (XMLEncoder) Marshal(v interface{}) ([]byte, error) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// Unmarshal implements Encoder
+func (XMLEncoder) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// EncoderRegistry holds the encoders available for content negotiation.
+// The first encoder registered is used when the client has no
+// preference. It's safe for concurrent use.
+type EncoderRegistry struct {
+	mu       sync.RWMutex
+	encoders []Encoder
+}
+
+// NewEncoderRegistry returns a registry holding the given encoders. With
+// no arguments it holds the built in JSON and XML encoders
+func NewEncoderRegistry(encoders ...Encoder) *EncoderRegistry {
+	if len(encoders) == 0 {
+		encoders = []Encoder{JSONEncoder{}, XMLEncoder{}}
+	}
+	reg := &EncoderRegistry{}
+	for _, e := range encoders {
+		reg.Register(e)
+	}
+	return reg
+}
+
+// Register adds an encoder, replacing any encoder already registered for
+// the same content type
+func (reg *EncoderRegistry) Register(e Encoder) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for i, existing := range reg.encoders {
+		if strings.EqualFold(existing.ContentType(), e.ContentType()) {
+			reg.encoders[i] = e
+			return
+		}
+	}
+	reg.encoders = append(reg.encoders, e)
+}
+
+// Lookup returns the encoder for an exact media type
+func (reg *EncoderRegistry) Lookup(mediaType string) (Encoder, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	for _, e := range reg.encoders {
+		if strings.EqualFold(e.ContentType(), mediaType) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Negotiate picks the encoder that best matches an Accept header. An
+// empty header means the client takes anything, so the first encoder is
+// returned. False means nothing acceptable is registered
+func (reg *EncoderRegistry) Negotiate(accept string) (Encoder, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	if len(reg.encoders) == 0 {
+		return nil, false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return reg.encoders[0], true
+	}
+
+	type mediaRange struct {
+		value string
+		q     float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		value, q := parseQuality(part)
+		if value != "" && q > 0 {
+			ranges = append(ranges, mediaRange{value: value, q: q})
+		}
+	}
+
+	// highest q first; for equal q the more specific range wins
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].value) > specificity(ranges[j].value)
+	})
+
+	for _, mr := range ranges {
+		for _, e := range reg.encoders {
+			if mediaTypeMatches(mr.value, e.ContentType()) {
+				return e, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// specificity ranks a media range: */* < type/* < type/subtype
+func specificity(mediaRange string) int {
+	switch {
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// mediaTypeMatches reports whether a media range from an Accept header
+// covers contentType
+func mediaTypeMatches(mediaRange, contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case mediaRange == "*/*":
+		return true
+	case strings.HasSuffix(mediaRange, "/*"):
+		return strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*"))
+	default:
+		return mediaRange == contentType
+	}
+}
+
+// encoders returns the registry configured on Tools, or one with the
+// built in encoders
+func (t *Tools) encoders() *EncoderRegistry {
+	if t.Encoders != nil {
+		return t.Encoders
+	}
+	return defaultEncoders
+}
+
+var defaultEncoders = NewEncoderRegistry()
+
+// WriteResponse works like ServeJSON, but picks the encoding from the
+// request's Accept header using the Encoders registry on Tools, which
+// defaults to JSON and XML. Custom headers are merged the same way
+// WriteJson does it. If the client accepts none of the registered types
+// nothing is written and a 406 error is returned, ready for ErrorJSON.
+func (t *Tools) WriteResponse(w http.ResponseWriter, r *http.Request, responseStatus int, data interface{}, headers ...http.Header) error {
+	encoder, ok := t.encoders().Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		return &Problem{
+			Status: http.StatusNotAcceptable,
+			Detail: fmt.Sprintf("none of the requested media types are supported: %s", r.Header.Get("Accept")),
+		}
+	}
+
+	out, err := encoder.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// caches need to know the body depends on Accept
+	w.Header().Add("Vary", "Accept")
+	return t.writeBody(w, r, responseStatus, out, encoder.ContentType(), headers...)
+}
+
+// ReadBody is the counterpart of WriteResponse. It decodes the request
+// body with the encoder registered for its Content-Type. JSON bodies go
+// through ReadJson, so all of its checks apply; other formats get the
+// same size limit, decompression and validation. A request without a
+// Content-Type is treated as JSON, and one we have no encoder for is
+// rejected with a 415.
+func (t *Tools) ReadBody(w http.ResponseWriter, r *http.Request, data interface{}) (err error) {
+	defer func() { err = t.LocalizeError(r, err) }()
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return t.ReadJson(w, r, data)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return unsupportedMediaType("mediatype.malformed")
+	}
+	if isJSONMediaType(mediaType) {
+		return t.ReadJson(w, r, data)
+	}
+
+	encoder, ok := t.encoders().Lookup(mediaType)
+	if !ok {
+		return unsupportedMediaType("mediatype.unsupported", mediaType)
+	}
+
+	maxBytes := t.maxJSONBytes()
+	body, err := t.openBody(w, r, maxBytes)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// XML carries its own encoding declaration, so only transcode when
+	// the header names a charset
+	decoded, err := transcodeToUTF8(body, params["charset"])
+	if err != nil {
+		return err
+	}
+
+	raw, err := io.ReadAll(decoded)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return badRequest("json.too_large", maxBytes)
+		}
+		return err
+	}
+	if len(raw) == 0 {
+		return badRequest("json.empty")
+	}
+
+	if err = encoder.Unmarshal(raw, data); err != nil {
+		return badRequest("body.malformed", mediaType, err.Error())
+	}
+
+	return t.Validate(data)
+}
+
+// MarshalXML writes a JSONResponse as a <response> element, with an
+// element per JSON member under the same name. Validation errors become
+// <field name="...">reason</field> elements, since XML has no maps
+func (j JSONResponse) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "response"}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	element := func(name string, v interface{}) error {
+		return e.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+	}
+
+	if err := element("error", j.Error); err != nil {
+		return err
+	}
+	if err := element("message", j.Message); err != nil {
+		return err
+	}
+	if j.Data != nil {
+		if err := element("data", j.Data); err != nil {
+			return err
+		}
+	}
+
+	if len(j.Errors) > 0 {
+		errorsStart := xml.StartElement{Name: xml.Name{Local: "errors"}}
+		if err := e.EncodeToken(errorsStart); err != nil {
+			return err
+		}
+
+		// sort the fields so the output is stable
+		names := make([]string, 0, len(j.Errors))
+		for name := range j.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			field := xml.StartElement{
+				Name: xml.Name{Local: "field"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+			}
+			if err := e.EncodeElement(j.Errors[name], field); err != nil {
+				return err
+			}
+		}
+		if err := e.EncodeToken(errorsStart.End()); err != nil {
+			return err
+		}
+	}
+
+	if j.Meta != nil {
+		if err := element("meta", j.Meta); err != nil {
+			return err
+		}
+	}
+	for _, member := range []struct{ name, value string }{
+		{"code", j.Code},
+		{"correlation_id", j.CorrelationID},
+		{"request_id", j.RequestID},
+	} {
+		if member.value == "" {
+			continue
+		}
+		if err := element(member.name, member.value); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,191 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// gobEncoder is the sort of compact binary format a caller might add
+type gobEncoder struct{}
+
+func (gobEncoder) ContentType() string { return "application/x-gob" }
+
+func (gobEncoder) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobEncoder) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var negotiateTests = []struct {
+	name                string
+	accept              string
+	expectedContentType string
+	errorExpected       bool
+}{
+	{name: "no accept", accept: "", expectedContentType: "application/json"},
+	{name: "json", accept: "application/json", expectedContentType: "application/json"},
+	{name: "xml", accept: "application/xml", expectedContentType: "application/xml"},
+	{name: "xml preferred", accept: "application/json;q=0.5, application/xml", expectedContentType: "application/xml"},
+	{name: "wildcard", accept: "*/*", expectedContentType: "application/json"},
+	{name: "specific beats wildcard", accept: "*/*, application/xml", expectedContentType: "application/xml"},
+	{name: "subtype wildcard", accept: "application/*", expectedContentType: "application/json"},
+	{name: "custom encoder", accept: "application/x-gob", expectedContentType: "application/x-gob"},
+	{name: "not acceptable", accept: "text/html", errorExpected: true},
+	{name: "refused", accept: "application/json;q=0, application/xml;q=0, application/x-gob;q=0", errorExpected: true},
+}
+
+func TestTools_WriteResponse(t *testing.T) {
+	testTools := Tools{Encoders: NewEncoderRegistry()}
+	testTools.Encoders.Register(gobEncoder{})
+
+	for _, e := range negotiateTests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if e.accept != "" {
+			req.Header.Set("Accept", e.accept)
+		}
+		rr := httptest.NewRecorder()
+
+		headers := make(http.Header)
+		headers.Add("FOO", "BAR")
+
+		err := testTools.WriteResponse(rr, req, http.StatusOK, JSONResponse{Message: "foo"}, headers)
+		if e.errorExpected {
+			var p *Problem
+			if !errors.As(err, &p) || p.Status != http.StatusNotAcceptable {
+				t.Errorf("%s: expected a 406 error but got %v", e.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			continue
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != e.expectedContentType {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expectedContentType, ct)
+		}
+		if rr.Header().Get("Foo") != "BAR" {
+			t.Errorf("%s: custom header missing", e.name)
+		}
+	}
+}
+
+func TestTools_WriteResponseXML(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+
+	payload := JSONResponse{
+		Error:   true,
+		Message: "validation failed",
+		Errors:  map[string]string{"name": "is required", "email": "must be a valid email address"},
+	}
+	if err := testTools.WriteResponse(rr, req, http.StatusUnprocessableEntity, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := xml.Header + `<response><error>true</error><message>validation failed</message>` +
+		`<errors><field name="email">must be a valid email address</field><field name="name">is required</field></errors></response>`
+	if rr.Body.String() != expected {
+		t.Errorf("wrong XML written:\n%s", rr.Body.String())
+	}
+}
+
+// every JSON member must have an XML element, so this fills in each
+// field of JSONResponse and looks for its element
+func TestJSONResponse_MarshalXMLFields(t *testing.T) {
+	var payload JSONResponse
+	v := reflect.ValueOf(&payload).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.String:
+			field.SetString("x")
+		case reflect.Interface:
+			field.Set(reflect.ValueOf("x"))
+		case reflect.Map:
+			field.Set(reflect.ValueOf(map[string]string{"x": "y"}))
+		case reflect.Ptr:
+			field.Set(reflect.New(field.Type().Elem()))
+		default:
+			t.Fatalf("don't know how to fill in %s", v.Type().Field(i).Name)
+		}
+	}
+
+	out, err := xml.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < v.NumField(); i++ {
+		name, _ := jsonFieldName(v.Type().Field(i))
+		if !strings.Contains(string(out), "<"+name+">") {
+			t.Errorf("no <%s> element in %s", name, out)
+		}
+	}
+
+	meta := JSONResponse{Meta: &PageMeta{Page: 2, Limit: 10}}
+	out, _ = xml.Marshal(meta)
+	if !strings.Contains(string(out), "<meta><page>2</page><limit>10</limit></meta>") {
+		t.Errorf("wrong meta element: %s", out)
+	}
+}
+
+var readBodyTests = []struct {
+	name          string
+	contentType   string
+	body          string
+	errorExpected bool
+}{
+	{name: "json", contentType: "application/json", body: `{"foo": "bar"}`},
+	{name: "no content type", contentType: "", body: `{"foo": "bar"}`},
+	{name: "xml", contentType: "application/xml", body: `<payload><foo>bar</foo></payload>`},
+	{name: "xml latin1", contentType: "application/xml; charset=iso-8859-1", body: `<payload><foo>bar</foo></payload>`},
+	{name: "bad xml", contentType: "application/xml", body: `<payload><foo>bar</payload>`, errorExpected: true},
+	{name: "empty xml", contentType: "application/xml", body: ``, errorExpected: true},
+	{name: "unsupported", contentType: "text/csv", body: `foo,bar`, errorExpected: true},
+	{name: "xml too large", contentType: "application/xml", body: `<payload><foo>` + strings.Repeat("a", 2048) + `</foo></payload>`, errorExpected: true},
+}
+
+func TestTools_ReadBody(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 1024}
+
+	for _, e := range readBodyTests {
+		var decoded struct {
+			XMLName xml.Name `xml:"payload" json:"-"`
+			Foo     string   `xml:"foo" json:"foo"`
+		}
+
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.body))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+
+		err := testTools.ReadBody(httptest.NewRecorder(), req, &decoded)
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: error expected, but none received", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error not expected but one received: %s", e.name, err)
+		} else if decoded.Foo != "bar" {
+			t.Errorf("%s: wrong value decoded: %s", e.name, decoded.Foo)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact decimal number, for money amounts and other values
+// that must not pass through float64. It decodes from a JSON number or a
+// string holding one, keeps the digits exactly as sent, and encodes back
+// as a JSON number:
+//
+//	Price toolkit.Decimal `json:"price" validate:"required,min=0"`
+//
+// The zero value is 0.
+type Decimal struct {
+	text string
+	// bad marks a value that failed to decode, so ReadJson can find it
+	bad bool
+}
+
+// ParseDecimal reads a decimal number such as "-12.50" or "1e-3".
+// Numbers with more than 1000 digits, or an exponent beyond ±1000, are
+// refused, since comparing them exactly costs too much
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if !isJSONNumber(s) {
+		return Decimal{}, fmt.Errorf("%q is not a decimal number", s)
+	}
+	if !numberInBounds(s) {
+		return Decimal{}, fmt.Errorf("%q is too large to compare exactly", s)
+	}
+	return Decimal{text: s}, nil
+}
+
+// String returns the number as it was sent
+func (d Decimal) String() string {
+	if d.text == "" {
+		return "0"
+	}
+	return d.text
+}
+
+// Rat returns the exact value of d
+func (d Decimal) Rat() *big.Rat {
+	r, ok := parseRat(d.String())
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// Float64 returns the nearest float64 to d, for when precision no longer
+// matters
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// Cmp compares d and e, returning -1, 0 or +1
+func (d Decimal) Cmp(e Decimal) int {
+	return d.Rat().Cmp(e.Rat())
+}
+
+// MarshalJSON writes d as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number, or a string holding one, since
+// some clients quote amounts to protect them from their own floats.
+// Anything else is a *json.UnmarshalTypeError, so the decoder can say
+// which field it was
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		*d = Decimal{bad: true}
+		return &json.UnmarshalTypeError{Value: "string " + strconv.Quote(s), Type: decimalType}
+	}
+	*d = parsed
+	return nil
+}
+
+// isJSONNumber reports whether s is a number in JSON syntax
+func isJSONNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	var n json.Number
+	return json.Unmarshal([]byte(s), &n) == nil && n.String() == s
+}
+
+// Client numbers are compared exactly as big.Rat values, which costs
+// time in proportion to the exponent: 1e999999 takes tens of
+// milliseconds. Numbers outside these bounds are never parsed
+const (
+	maxNumberDigits   = 1000
+	maxNumberExponent = 1000
+)
+
+// numberInBounds reports whether the JSON number s is small enough, in
+// digits and exponent, to be made into a big.Rat cheaply
+func numberInBounds(s string) bool {
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(s), "e")
+	mantissa = strings.Replace(strings.TrimPrefix(mantissa, "-"), ".", "", 1)
+	if len(mantissa) > maxNumberDigits {
+		return false
+	}
+	if hasExponent {
+		e, err := strconv.Atoi(exponent)
+		if err != nil || e > maxNumberExponent || e < -maxNumberExponent {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRat parses the JSON number s exactly. It fails for numbers
+// outside the bounds, as well as for ones that don't parse
+func parseRat(s string) (*big.Rat, bool) {
+	if !numberInBounds(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// decimalType is used by the validator to compare decimals exactly
+var decimalType = reflect.TypeOf(Decimal{})
+
+// decimalError turns a value a Decimal field in data couldn't take into
+// a field error. It returns nil for any other type error
+func decimalError(err *json.UnmarshalTypeError, data interface{}) error {
+	if err.Type != decimalType {
+		return nil
+	}
+
+	// some versions of encoding/json name the field, others leave
+	// errors from UnmarshalJSON as they are, so then the bad value is
+	// looked for
+	path, ok := typeErrorPath(err.Field), err.Field != ""
+	if !ok {
+		path, ok = badDecimalPath(reflect.ValueOf(data), "")
+	}
+	if !ok {
+		return nil
+	}
+
+	var ve ValidationError
+	ve.add(path, "type", msg("validation.number"))
+	return &ve
+}
+
+// badDecimalPath returns the path of the Decimal in v that failed to
+// decode, and false if there isn't one. path is the path of v
+func badDecimalPath(v reflect.Value, path string) (string, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == decimalType:
+		return path, v.FieldByName("bad").Bool()
+	case v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			name, ok := jsonFieldName(sf)
+			if !ok || !sf.IsExported() {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			if sf.Anonymous && sf.Tag.Get("json") == "" {
+				fieldPath = path
+			}
+			if found, ok := badDecimalPath(v.Field(i), fieldPath); ok {
+				return found, true
+			}
+		}
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if found, ok := badDecimalPath(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); ok {
+				return found, true
+			}
+		}
+	case v.Kind() == reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if found, ok := badDecimalPath(iter.Value(), joinPath(path, fmt.Sprint(iter.Key().Interface()))); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
+
+// intOverflowError turns a number too large or too small for a sized int
+// field into a field error naming the allowed range. It returns nil for
+// any other type error
+func intOverflowError(err *json.UnmarshalTypeError) error {
+	literal := strings.TrimPrefix(err.Value, "number ")
+	if literal == err.Value || err.Type == nil || strings.ContainsAny(literal, ".eE") {
+		return nil
+	}
+
+	var min, max string
+	switch err.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := err.Type.Bits()
+		min = strconv.FormatInt(-1<<(bits-1), 10)
+		max = strconv.FormatInt(1<<(bits-1)-1, 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min = "0"
+		max = strconv.FormatUint(^uint64(0)>>(64-err.Type.Bits()), 10)
+	default:
+		return nil
+	}
+
+	var ve ValidationError
+	ve.add(typeErrorPath(err.Field), "range", msg("validation.range", min, max))
+	return &ve
+}
+
+// typeErrorPath turns the Field of a json.UnmarshalTypeError, such as
+// items.0.n, into the path style validation uses, items[0].n
+func typeErrorPath(field string) string {
+	var path string
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil && path != "" {
+			path += "[" + part + "]"
+			continue
+		}
+		path = joinPath(path, part)
+	}
+	return path
+}
+
+// checkDecimal handles min, max and len for a Decimal, comparing exactly
+func checkDecimal(value reflect.Value, name, param string) (message, error) {
+	d, ok := value.Interface().(Decimal)
+	if !ok {
+		return message{}, errors.New("not a decimal")
+	}
+	limit, ok := new(big.Rat).SetString(param)
+	if !ok {
+		return message{}, fmt.Errorf("invalid %s parameter %q", name, param)
+	}
+
+	cmp := d.Rat().Cmp(limit)
+	switch {
+	case name == "min" && cmp < 0,
+		name == "max" && cmp > 0,
+		name == "len" && cmp != 0:
+		return msg("validation."+name, param), nil
+	}
+	return message{}, nil
+}
@@ -0,0 +1,134 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_ReadJsonUseNumber(t *testing.T) {
+	body := `{"id":9007199254740993,"amount":0.1}`
+
+	var testTools Tools
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+	var lossy map[string]interface{}
+	_ = testTools.ReadJson(httptest.NewRecorder(), req, &lossy)
+	if _, ok := lossy["id"].(float64); !ok {
+		t.Fatalf("expected float64 without UseNumber but got %T", lossy["id"])
+	}
+
+	testTools.UseNumber = true
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(body))
+	var exact map[string]interface{}
+	if err := testTools.ReadJson(httptest.NewRecorder(), req, &exact); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := exact["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("expected the exact ID but got %v (%T)", exact["id"], exact["id"])
+	}
+}
+
+type order struct {
+	Price    Decimal  `json:"price" validate:"required,min=0.01,max=1000"`
+	Discount *Decimal `json:"discount"`
+	Qty      int8     `json:"qty"`
+	Lines    []struct {
+		Count  uint16  `json:"count"`
+		Amount Decimal `json:"amount"`
+	} `json:"lines"`
+}
+
+var numberTests = []struct {
+	name            string
+	json            string
+	expectedPrice   string
+	expectedFields  map[string]string
+	expectedMessage string
+}{
+	{name: "exact decimal", json: `{"price":19.990000000000000001,"discount":"0.10"}`, expectedPrice: "19.990000000000000001"},
+	{name: "quoted decimal", json: `{"price":"5"}`, expectedPrice: "5"},
+	{name: "decimal too small", json: `{"price":0.001}`, expectedFields: map[string]string{"price": "must be at least 0.01"}},
+	{name: "decimal missing", json: `{"qty":1}`, expectedFields: map[string]string{"price": "is required"}},
+	{name: "not a decimal", json: `{"price":"lots"}`, expectedFields: map[string]string{"price": "must be a number"}},
+	{name: "decimal too large", json: `{"price":1,"discount":1e999999}`, expectedFields: map[string]string{"discount": "must be a number"}},
+	{name: "bad decimal in a list", json: `{"price":1,"lines":[{"count":1,"amount":"x"}]}`, expectedFields: map[string]string{"lines[0].amount": "must be a number"}},
+	{name: "int overflow", json: `{"price":1,"qty":300}`, expectedFields: map[string]string{"qty": "must be between -128 and 127"}},
+	{name: "negative uint", json: `{"price":1,"lines":[{"count":-1}]}`, expectedFields: map[string]string{"lines[0].count": "must be between 0 and 65535"}},
+	{name: "fraction is still a type error", json: `{"price":1,"qty":1.5}`, expectedMessage: `body contains incorrect JSON type for field "qty"`},
+}
+
+func TestTools_ReadJsonNumbers(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range numberTests {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.json))
+		var o order
+		err := testTools.ReadJson(httptest.NewRecorder(), req, &o)
+
+		switch {
+		case e.expectedPrice != "":
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+				continue
+			}
+			if o.Price.String() != e.expectedPrice {
+				t.Errorf("%s: expected price %s but got %s", e.name, e.expectedPrice, o.Price)
+			}
+			out, _ := json.Marshal(o.Price)
+			if string(out) != e.expectedPrice {
+				t.Errorf("%s: price encoded as %s", e.name, out)
+			}
+
+		case e.expectedFields != nil:
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Errorf("%s: expected a validation error but got %v", e.name, err)
+				continue
+			}
+			fields := ve.Fields()
+			for k, v := range e.expectedFields {
+				// older toolchains leave array indexes out of the path
+				got, ok := fields[k]
+				if !ok {
+					got = fields[strings.Replace(k, "[0]", "", 1)]
+				}
+				if got != v {
+					t.Errorf("%s: %s: expected %q but got %v", e.name, k, v, fields)
+				}
+			}
+
+		default:
+			if err == nil || !strings.Contains(err.Error(), e.expectedMessage) {
+				t.Errorf("%s: expected %q but got %v", e.name, e.expectedMessage, err)
+			}
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	a, _ := ParseDecimal("0.1")
+	b, _ := ParseDecimal("0.10")
+	if a.Cmp(b) != 0 || a.String() != "0.1" || b.String() != "0.10" {
+		t.Errorf("wrong comparison of %s and %s", a, b)
+	}
+
+	var zero Decimal
+	if zero.String() != "0" || zero.Float64() != 0 {
+		t.Errorf("wrong zero value: %s", zero)
+	}
+
+	if _, err := ParseDecimal("1,5"); err == nil {
+		t.Error("expected 1,5 to be rejected")
+	}
+	for _, s := range []string{"1e999999", "1e-1001", strings.Repeat("9", 1001)} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("expected %.20s to be refused", s)
+		}
+	}
+	if _, err := ParseDecimal("1e1000"); err != nil {
+		t.Errorf("expected 1e1000 to be accepted but got %s", err)
+	}
+}
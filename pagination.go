@@ -0,0 +1,172 @@
+package toolkit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Pagination is the page a client asked for, as read by ReadPagination.
+// Either Page or Cursor is used, never both
+type Pagination struct {
+	// Page is the 1-based page number. It's 0 when a cursor was sent
+	Page int
+	// Limit is the number of items per page
+	Limit int
+	// Cursor is the opaque position sent back from a previous page
+	Cursor string
+}
+
+// Offset is the number of items to skip for page based paging
+func (p Pagination) Offset() int {
+	if p.Page < 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.Limit
+}
+
+// PageMeta describes a page of results. It goes in the meta member of
+// JSONResponse, and PageLinks turns it into Link headers
+type PageMeta struct {
+	Page       int    `json:"page,omitempty" xml:"page,omitempty"`
+	Limit      int    `json:"limit" xml:"limit"`
+	Total      *int64 `json:"total,omitempty" xml:"total,omitempty"`
+	TotalPages int    `json:"total_pages,omitempty" xml:"total_pages,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty" xml:"prev_cursor,omitempty"`
+}
+
+// Meta builds the metadata for page based paging, given the total
+// number of items in the list
+func (p Pagination) Meta(total int64) *PageMeta {
+	meta := &PageMeta{Page: p.Page, Limit: p.Limit, Total: &total}
+	if p.Limit > 0 {
+		meta.TotalPages = int((total + int64(p.Limit) - 1) / int64(p.Limit))
+	}
+	return meta
+}
+
+// CursorMeta builds the metadata for cursor based paging. Pass an empty
+// string for a cursor when there's no page in that direction
+func (p Pagination) CursorMeta(next, prev string) *PageMeta {
+	return &PageMeta{Limit: p.Limit, NextCursor: next, PrevCursor: prev}
+}
+
+// ReadPagination reads the page, limit and cursor query parameters. The
+// limit defaults to DefaultPageSize and may not exceed MaxPageSize; the
+// page defaults to 1 unless a cursor is sent. Bad values come back
+// together as a *ValidationError, which ErrorJSON sends as a 422.
+func (t *Tools) ReadPagination(r *http.Request) (Pagination, error) {
+	defaultSize := 20
+	if t.DefaultPageSize != 0 {
+		defaultSize = t.DefaultPageSize
+	}
+	maxSize := 100
+	if t.MaxPageSize != 0 {
+		maxSize = t.MaxPageSize
+	}
+
+	query := r.URL.Query()
+	p := Pagination{Limit: defaultSize, Cursor: query.Get("cursor")}
+	var ve ValidationError
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		switch {
+		case err != nil:
+			ve.add("limit", "type", msg("validation.whole_number"))
+		case limit < 1:
+			ve.add("limit", "min", msg("validation.min", 1))
+		case limit > maxSize:
+			ve.add("limit", "max", msg("validation.max", maxSize))
+		default:
+			p.Limit = limit
+		}
+	}
+
+	if s := query.Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		switch {
+		case err != nil:
+			ve.add("page", "type", msg("validation.whole_number"))
+		case page < 1:
+			ve.add("page", "min", msg("validation.min", 1))
+		case p.Cursor != "":
+			ve.add("page", "exclusive", msg("validation.exclusive", "cursor"))
+		case p.Limit > 0 && page > math.MaxInt/p.Limit+1:
+			// Offset would overflow
+			ve.add("page", "max", msg("validation.max", math.MaxInt/p.Limit+1))
+		default:
+			p.Page = page
+		}
+	} else if p.Cursor == "" {
+		p.Page = 1
+	}
+
+	if len(ve.Errors) > 0 {
+		return Pagination{}, t.LocalizeError(r, &ve)
+	}
+	return p, nil
+}
+
+// PageLinks builds RFC 8288 Link headers (first, prev, next and last)
+// for a page, ready to pass as the headers argument of WriteJson. The
+// links keep the request's other query parameters.
+func (t *Tools) PageLinks(r *http.Request, meta *PageMeta) http.Header {
+	headers := make(http.Header)
+	link := func(rel string, set map[string]string) {
+		headers.Add("Link", fmt.Sprintf("<%s>; rel=%q", pageURL(r.URL, set), rel))
+	}
+	limit := strconv.Itoa(meta.Limit)
+
+	// cursor based paging
+	if meta.Page == 0 {
+		link("first", map[string]string{"limit": limit, "cursor": ""})
+		if meta.PrevCursor != "" {
+			link("prev", map[string]string{"limit": limit, "cursor": meta.PrevCursor})
+		}
+		if meta.NextCursor != "" {
+			link("next", map[string]string{"limit": limit, "cursor": meta.NextCursor})
+		}
+		return headers
+	}
+
+	page := func(n int) map[string]string {
+		return map[string]string{"limit": limit, "page": strconv.Itoa(n)}
+	}
+	link("first", page(1))
+	if meta.Page > 1 {
+		link("prev", page(meta.Page-1))
+	}
+	if meta.Page < meta.TotalPages {
+		link("next", page(meta.Page+1))
+	}
+	if meta.TotalPages > 0 {
+		link("last", page(meta.TotalPages))
+	}
+	return headers
+}
+
+// pageURL copies u with the given query parameters replaced. An empty
+// value removes the parameter
+func pageURL(u *url.URL, set map[string]string) string {
+	next := *u
+	query := next.Query()
+	for k, v := range set {
+		if v == "" {
+			query.Del(k)
+			continue
+		}
+		query.Set(k, v)
+	}
+	// a cursor and a page number don't mix
+	if _, ok := set["cursor"]; ok {
+		query.Del("page")
+	} else {
+		query.Del("cursor")
+	}
+	next.RawQuery = query.Encode()
+	return next.String()
+}
@@ -0,0 +1,130 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var paginationTests = []struct {
+	name           string
+	query          string
+	expected       Pagination
+	expectedFields []string
+}{
+	{name: "defaults", query: "", expected: Pagination{Page: 1, Limit: 20}},
+	{name: "page and limit", query: "page=3&limit=50", expected: Pagination{Page: 3, Limit: 50}, expectedFields: nil},
+	{name: "cursor", query: "cursor=abc&limit=5", expected: Pagination{Limit: 5, Cursor: "abc"}},
+	{name: "limit too large", query: "limit=101", expectedFields: []string{"limit"}},
+	{name: "bad values", query: "page=0&limit=x", expectedFields: []string{"limit", "page"}},
+	{name: "page and cursor", query: "page=2&cursor=abc", expectedFields: []string{"page"}},
+	{name: "page overflows offset", query: "page=9223372036854775807&limit=50", expectedFields: []string{"page"}},
+}
+
+func TestTools_ReadPagination(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range paginationTests {
+		req, _ := http.NewRequest("GET", "/items?"+e.query, nil)
+
+		p, err := testTools.ReadPagination(req)
+		if e.expectedFields == nil {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			}
+			if p != e.expected {
+				t.Errorf("%s: expected %+v but got %+v", e.name, e.expected, p)
+			}
+			continue
+		}
+
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%s: expected a validation error but got %v", e.name, err)
+			continue
+		}
+		for _, field := range e.expectedFields {
+			if _, ok := ve.Fields()[field]; !ok {
+				t.Errorf("%s: expected an error for %s but got %v", e.name, field, ve.Fields())
+			}
+		}
+	}
+
+	// the limits can be changed
+	testTools.DefaultPageSize, testTools.MaxPageSize = 5, 10
+	req, _ := http.NewRequest("GET", "/items", nil)
+	if p, _ := testTools.ReadPagination(req); p.Limit != 5 {
+		t.Errorf("expected default limit 5 but got %d", p.Limit)
+	}
+	req, _ = http.NewRequest("GET", "/items?limit=11", nil)
+	if _, err := testTools.ReadPagination(req); err == nil {
+		t.Error("expected an error for a limit over MaxPageSize")
+	}
+}
+
+func TestTools_PageLinks(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/items?page=2&limit=10&sort=name", nil)
+	p, err := testTools.ReadPagination(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := p.Meta(35)
+	if meta.TotalPages != 4 || *meta.Total != 35 || p.Offset() != 10 {
+		t.Errorf("wrong meta: %+v", meta)
+	}
+
+	rr := httptest.NewRecorder()
+	err = testTools.WriteJson(rr, http.StatusOK, JSONResponse{Data: []int{1, 2}, Meta: meta}, testTools.PageLinks(req, meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`</items?limit=10&page=1&sort=name>; rel="first"`,
+		`</items?limit=10&page=1&sort=name>; rel="prev"`,
+		`</items?limit=10&page=3&sort=name>; rel="next"`,
+		`</items?limit=10&page=4&sort=name>; rel="last"`,
+	}
+	if got := rr.Header()["Link"]; strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("wrong links:\n%s", strings.Join(got, "\n"))
+	}
+
+	var payload struct {
+		Meta PageMeta `json:"meta"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&payload)
+	if payload.Meta.Page != 2 || payload.Meta.TotalPages != 4 {
+		t.Errorf("wrong meta in body: %+v", payload.Meta)
+	}
+
+	// the last page has no next link
+	req, _ = http.NewRequest("GET", "/items?page=4&limit=10", nil)
+	p, _ = testTools.ReadPagination(req)
+	for _, l := range testTools.PageLinks(req, p.Meta(35))["Link"] {
+		if strings.Contains(l, `rel="next"`) {
+			t.Error("unexpected next link on the last page")
+		}
+	}
+}
+
+func TestTools_PageLinksCursor(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/items?cursor=abc&limit=10", nil)
+	p, _ := testTools.ReadPagination(req)
+
+	links := testTools.PageLinks(req, p.CursorMeta("def", ""))["Link"]
+	expected := []string{
+		`</items?limit=10>; rel="first"`,
+		`</items?cursor=def&limit=10>; rel="next"`,
+	}
+	if strings.Join(links, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("wrong links:\n%s", strings.Join(links, "\n"))
+	}
+}
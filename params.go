@@ -0,0 +1,239 @@
+package toolkit
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Params reads typed values from the query string, headers or path of a
+// request. Each reader takes a default that's returned when the value is
+// missing or bad. Bad values don't stop anything; they're collected, and
+// Err returns them all as one *ValidationError, just like ReadJson does
+// for bodies:
+//
+//	p := tools.Params(r)
+//	page := p.Int("page", 1)
+//	since := p.Time("since", time.RFC3339, time.Time{})
+//	tags := p.List("tags", nil)
+//	token := p.Header().String("X-Api-Token", "")
+//	if err := p.Err(); err != nil {
+//		_ = tools.ErrorJSON(w, err)
+//		return
+//	}
+type Params struct {
+	// lookup returns every value sent for key
+	lookup func(key string) ([]string, bool)
+	r      *http.Request
+	tools  *Tools
+	errs   *ValidationError
+}
+
+// Params returns a reader for the request's query string. Use Header and
+// Path on it to read other parts of the request into the same errors.
+func (t *Tools) Params(r *http.Request) *Params {
+	query := r.URL.Query()
+	return &Params{
+		lookup: func(key string) ([]string, bool) {
+			values, ok := query[key]
+			return values, ok
+		},
+		r:     r,
+		tools: t,
+		errs:  &ValidationError{},
+	}
+}
+
+// Header returns a reader for the request headers that shares p's
+// errors
+func (p *Params) Header() *Params {
+	return &Params{
+		lookup: func(key string) ([]string, bool) {
+			values := p.r.Header.Values(key)
+			return values, len(values) > 0
+		},
+		r:     p.r,
+		tools: p.tools,
+		errs:  p.errs,
+	}
+}
+
+// Path returns a reader for path parameters that shares p's errors.
+// Routers keep path parameters in different places, so the caller
+// supplies the lookup, e.g. for chi:
+//
+//	p.Path(func(k string) (string, bool) { v := chi.URLParam(r, k); return v, v != "" })
+func (p *Params) Path(lookup func(key string) (string, bool)) *Params {
+	return &Params{
+		lookup: func(key string) ([]string, bool) {
+			value, ok := lookup(key)
+			return []string{value}, ok
+		},
+		r:     p.r,
+		tools: p.tools,
+		errs:  p.errs,
+	}
+}
+
+// Err returns every bad or missing value as a *ValidationError, or nil
+// if there were none. The reasons are in the request's language
+func (p *Params) Err() error {
+	if len(p.errs.Errors) == 0 {
+		return nil
+	}
+	return p.tools.LocalizeError(p.r, p.errs)
+}
+
+// get returns the first value for key, trimmed, and false if it's
+// missing or empty
+func (p *Params) get(key string) (string, bool) {
+	values, ok := p.lookup(key)
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	s := strings.TrimSpace(values[0])
+	return s, s != ""
+}
+
+// Require records an error for each key that's missing or empty
+func (p *Params) Require(keys ...string) *Params {
+	for _, key := range keys {
+		if _, ok := p.get(key); !ok {
+			p.errs.add(key, "required", msg("validation.required"))
+		}
+	}
+	return p
+}
+
+// String returns the value for key, or def
+func (p *Params) String(key, def string) string {
+	if s, ok := p.get(key); ok {
+		return s
+	}
+	return def
+}
+
+// Int returns the value for key as an int, or def
+func (p *Params) Int(key string, def int) int {
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		p.errs.add(key, "type", msg("validation.whole_number"))
+		return def
+	}
+	return i
+}
+
+// Int64 returns the value for key as an int64, or def
+func (p *Params) Int64(key string, def int64) int64 {
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.errs.add(key, "type", msg("validation.whole_number"))
+		return def
+	}
+	return i
+}
+
+// Float returns the value for key as a float64, or def
+func (p *Params) Float(key string, def float64) float64 {
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.errs.add(key, "type", msg("validation.number"))
+		return def
+	}
+	return f
+}
+
+// Bool returns the value for key as a bool, or def. It accepts the same
+// spellings as strconv.ParseBool: 1, t, true, 0, f, false and so on
+func (p *Params) Bool(key string, def bool) bool {
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		p.errs.add(key, "type", msg("validation.bool"))
+		return def
+	}
+	return b
+}
+
+// Duration returns the value for key as a time.Duration, e.g. 1h30m, or
+// def
+func (p *Params) Duration(key string, def time.Duration) time.Duration {
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		p.errs.add(key, "type", msg("validation.duration"))
+		return def
+	}
+	return d
+}
+
+// Time returns the value for key parsed with layout, or def. An empty
+// layout means time.RFC3339
+func (p *Params) Time(key, layout string, def time.Time) time.Time {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	tm, err := time.Parse(layout, s)
+	if err != nil {
+		p.errs.add(key, "type", msg("validation.time_format", layout))
+		return def
+	}
+	return tm
+}
+
+// Enum returns the value for key if it's one of allowed, or def
+func (p *Params) Enum(key string, allowed []string, def string) string {
+	s, ok := p.get(key)
+	if !ok {
+		return def
+	}
+	for _, a := range allowed {
+		if s == a {
+			return s
+		}
+	}
+	p.errs.add(key, "oneof", msg("validation.oneof", strings.Join(allowed, ", ")))
+	return def
+}
+
+// List returns the comma separated values for key, or def. Repeated
+// keys, e.g. ?tag=a&tag=b, add to the list. Empty elements are dropped
+func (p *Params) List(key string, def []string) []string {
+	values, ok := p.lookup(key)
+	if !ok {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(strings.Join(values, ","), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
@@ -0,0 +1,106 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_Params(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/?page=3&ratio=0.5&active=true&wait=1m30s&since=2024-01-02T03:04:05Z&sort=name&tags=a,+b,,c&tag=x&tag=y&big=9000000000&limit=5&limit=10&name=a,b&name=c", nil)
+	req.Header.Set("X-Retries", "4")
+	pathParams := map[string]string{"id": "42"}
+
+	p := testTools.Params(req)
+	page := p.Int("page", 1)
+	big := p.Int64("big", 0)
+	ratio := p.Float("ratio", 0)
+	active := p.Bool("active", false)
+	wait := p.Duration("wait", time.Second)
+	since := p.Time("since", "", time.Time{})
+	sort := p.Enum("sort", []string{"name", "date"}, "date")
+	tags := p.List("tags", nil)
+	tag := p.List("tag", nil)
+	// scalars take the first of repeated keys, commas and all
+	limit := p.Int("limit", 0)
+	name := p.String("name", "")
+	missing := p.String("missing", "default")
+	retries := p.Header().Int("X-Retries", 0)
+	id := p.Path(func(k string) (string, bool) { v, ok := pathParams[k]; return v, ok }).Int("id", 0)
+
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if page != 3 || big != 9000000000 || ratio != 0.5 || !active || wait != 90*time.Second || sort != "name" || missing != "default" || retries != 4 || id != 42 {
+		t.Errorf("wrong values read: %v %v %v %v %v %v %v %v %v", page, big, ratio, active, wait, sort, missing, retries, id)
+	}
+	if !since.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("wrong time read: %v", since)
+	}
+	if strings.Join(tags, "|") != "a|b|c" || strings.Join(tag, "|") != "x|y" {
+		t.Errorf("wrong lists read: %v %v", tags, tag)
+	}
+	if limit != 5 || name != "a,b" {
+		t.Errorf("wrong repeated values read: %v %v", limit, name)
+	}
+}
+
+func TestTools_ParamsErrors(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/?page=two&active=maybe&wait=soon&since=yesterday&sort=size&ratio=half", nil)
+	req.Header.Set("X-Retries", "lots")
+
+	p := testTools.Params(req).Require("q")
+	page := p.Int("page", 1)
+	p.Bool("active", false)
+	p.Duration("wait", time.Second)
+	p.Time("since", "2006-01-02", time.Time{})
+	sort := p.Enum("sort", []string{"name", "date"}, "date")
+	p.Float("ratio", 0)
+	p.Header().Int("X-Retries", 0)
+
+	// the defaults come back for bad values
+	if page != 1 || sort != "date" {
+		t.Errorf("expected defaults but got %d and %s", page, sort)
+	}
+
+	err := p.Err()
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected a validation error but got %v", err)
+	}
+
+	expected := map[string]string{
+		"q":         "is required",
+		"page":      "must be a whole number",
+		"active":    "must be true or false",
+		"wait":      "must be a duration such as 90s or 1h30m",
+		"since":     "must be a time in the format 2006-01-02",
+		"sort":      "must be one of: name, date",
+		"ratio":     "must be a number",
+		"X-Retries": "must be a whole number",
+	}
+	fields := ve.Fields()
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("%s: expected %q but got %q", k, v, fields[k])
+		}
+	}
+
+	// ErrorJSON renders it like any other validation error
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	var payload JSONResponse
+	_ = json.NewDecoder(rr.Body).Decode(&payload)
+	if rr.Code != http.StatusUnprocessableEntity || len(payload.Errors) != len(expected) {
+		t.Errorf("wrong error response: %d %v", rr.Code, payload.Errors)
+	}
+}
@@ -0,0 +1,547 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is wrapped by a PatchError when the patch document
+	// itself is broken, e.g. an unknown op or a malformed path
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchPath is wrapped by a PatchError when a path doesn't exist
+	// in the target document
+	ErrPatchPath = errors.New("path not found")
+	// ErrPatchTestFailed is wrapped by a PatchError when a test
+	// operation doesn't match
+	ErrPatchTestFailed = errors.New("test operation failed")
+)
+
+// PatchError reports the JSON Patch operation that failed. ErrorJSON
+// sends it as a 400 if the patch was invalid, a 409 if a test operation
+// failed, and a 422 if the patch couldn't be applied to the document
+type PatchError struct {
+	// Index is the position of the operation in the patch
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// ProblemDetails implements ProblemDetailer, so ErrorJSON picks the right
+// status code
+func (e *PatchError) ProblemDetails() *Problem {
+	status := http.StatusUnprocessableEntity
+	switch {
+	case errors.Is(e.Err, ErrInvalidPatch):
+		status = http.StatusBadRequest
+	case errors.Is(e.Err, ErrPatchTestFailed):
+		status = http.StatusConflict
+	}
+	return &Problem{Status: status, Detail: e.Error()}
+}
+
+// PatchOperation is one operation of an RFC 6902 JSON Patch
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// UnmarshalJSON ignores members it doesn't know, as RFC 6902 section 4
+// requires, even when AllowUnknownFields is off
+func (op *PatchOperation) UnmarshalJSON(b []byte) error {
+	type plain PatchOperation
+	return json.Unmarshal(b, (*plain)(op))
+}
+
+// JSONPatch is an RFC 6902 JSON Patch document
+type JSONPatch []PatchOperation
+
+// MergePatch is an RFC 7396 JSON Merge Patch document
+type MergePatch json.RawMessage
+
+// ReadJSONPatch reads a JSON Patch from the request body. It goes through
+// ReadJson, so the same size limits, content type checks and error
+// messages apply. The operations are checked before they're returned.
+func (t *Tools) ReadJSONPatch(w http.ResponseWriter, r *http.Request) (JSONPatch, error) {
+	var patch JSONPatch
+	if err := t.ReadJson(w, r, &patch); err != nil {
+		return nil, err
+	}
+	if err := patch.check(); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// ReadMergePatch reads a JSON Merge Patch from the request body. It goes
+// through ReadJson, so the same size limits, content type checks and
+// error messages apply.
+func (t *Tools) ReadMergePatch(w http.ResponseWriter, r *http.Request) (MergePatch, error) {
+	var raw json.RawMessage
+	if err := t.ReadJson(w, r, &raw); err != nil {
+		return nil, err
+	}
+	return MergePatch(raw), nil
+}
+
+// check makes sure every operation is one we know, with the members it
+// needs
+func (p JSONPatch) check() error {
+	for i, op := range p {
+		fail := func(format string, args ...interface{}) error {
+			return &PatchError{Index: i, Op: op.Op, Path: op.Path, Err: fmt.Errorf("%w: %s", ErrInvalidPatch, fmt.Sprintf(format, args...))}
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return fail("missing value")
+			}
+		case "remove":
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				return fail("bad from: %s", err)
+			}
+		default:
+			return fail("unknown op %q", op.Op)
+		}
+
+		if _, err := parsePointer(op.Path); err != nil {
+			return fail("bad path: %s", err)
+		}
+	}
+	return nil
+}
+
+// Apply applies the patch to a raw JSON document and returns the result.
+// Operations are applied in order and the whole patch fails if any of
+// them do.
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	node, err := decodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range p {
+		node, err = applyOperation(node, op)
+		if err != nil {
+			return nil, &PatchError{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return json.Marshal(node)
+}
+
+// ApplyTo applies the patch to a Go value, which must be a pointer. The
+// value is round-tripped through JSON, so paths use the JSON field names
+func (p JSONPatch) ApplyTo(v interface{}) error {
+	return applyToValue(v, p.Apply)
+}
+
+// Apply applies the merge patch to a raw JSON document and returns the
+// result
+func (p MergePatch) Apply(doc []byte) ([]byte, error) {
+	patch, err := decodeDocument(p)
+	if err != nil {
+		return nil, &Problem{Status: http.StatusBadRequest, Detail: "merge patch is not valid JSON"}
+	}
+
+	var target interface{}
+	if len(bytes.TrimSpace(doc)) > 0 {
+		if target, err = decodeDocument(doc); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(mergePatch(target, patch))
+}
+
+// ApplyTo applies the merge patch to a Go value, which must be a
+// pointer. The value is round-tripped through JSON, so members use the
+// JSON field names
+func (p MergePatch) ApplyTo(v interface{}) error {
+	return applyToValue(v, p.Apply)
+}
+
+// applyToValue marshals v, patches it, and decodes the result into a
+// fresh value so removed members end up empty. A patched document that
+// no longer fits v is a 422
+func applyToValue(v interface{}, apply func([]byte) ([]byte, error)) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("patch target must be a non-nil pointer")
+	}
+
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	patched, err := apply(doc)
+	if err != nil {
+		return err
+	}
+
+	fresh := reflect.New(rv.Elem().Type())
+	if err = json.Unmarshal(patched, fresh.Interface()); err != nil {
+		return &Problem{Status: http.StatusUnprocessableEntity, Detail: "patched document does not fit the target: " + err.Error()}
+	}
+	rv.Elem().Set(fresh.Elem())
+	return nil
+}
+
+// mergePatch implements the MergePatch algorithm from RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// decodeDocument decodes raw JSON, keeping numbers exact
+func decodeDocument(doc []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+
+	var node interface{}
+	if err := dec.Decode(&node); err != nil {
+		return nil, fmt.Errorf("error decoding JSON document: %w", err)
+	}
+	return node, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped
+// reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		// ~1 first, as RFC 6901 says, so "~01" becomes "~1"
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// applyOperation applies one operation to the document rooted at node
+// and returns the new root
+func applyOperation(node interface{}, op PatchOperation) (interface{}, error) {
+	path, _ := parsePointer(op.Path)
+
+	switch op.Op {
+	case "add", "replace", "test":
+		value, err := decodeDocument(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad value: %s", ErrInvalidPatch, err)
+		}
+		switch op.Op {
+		case "add":
+			return addValue(node, path, value)
+		case "replace":
+			return replaceValue(node, path, value)
+		}
+
+		current, err := getValue(node, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return node, nil
+
+	case "remove":
+		return removeValue(node, path)
+
+	default: // move and copy
+		from, _ := parsePointer(op.From)
+		value, err := getValue(node, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			return addValue(node, path, deepCopy(value))
+		}
+
+		// a value can't be moved into one of its own children
+		if op.From != op.Path && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("%w: cannot move %s into its own child", ErrInvalidPatch, op.From)
+		}
+		if node, err = removeValue(node, from); err != nil {
+			return nil, err
+		}
+		return addValue(node, path, value)
+	}
+}
+
+// getValue returns the value at path
+func getValue(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		child, err := childOf(node, token)
+		if err != nil {
+			return nil, err
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// addValue implements add: members are created or replaced, array
+// elements are inserted, and "-" appends
+func addValue(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyParent(node, path, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[token] = value
+			return p, nil
+		case []interface{}:
+			if token == "-" {
+				return append(p, value), nil
+			}
+			i, err := arrayIndex(token, len(p)+1)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		default:
+			return nil, fmt.Errorf("%w: cannot add to a %s", ErrPatchPath, jsonKind(parent))
+		}
+	})
+}
+
+// removeValue implements remove. The target must exist
+func removeValue(node interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return modifyParent(node, path, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[token]; !ok {
+				return nil, fmt.Errorf("%w: member %q does not exist", ErrPatchPath, token)
+			}
+			delete(p, token)
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(p))
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: cannot remove from a %s", ErrPatchPath, jsonKind(parent))
+		}
+	})
+}
+
+// replaceValue implements replace. The target must exist
+func replaceValue(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if _, err := getValue(node, path); err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyParent(node, path, func(parent interface{}, token string) (interface{}, error) {
+		return setChild(parent, token, value)
+	})
+}
+
+// modifyParent walks to the parent of the last token in path and calls
+// fn with it. Arrays can change when they grow or shrink, so each level
+// is written back into its own parent on the way out
+func modifyParent(node interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	child, err := childOf(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	newChild, err := modifyParent(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	return setChild(node, path[0], newChild)
+}
+
+// childOf returns an existing member or array element
+func childOf(node interface{}, token string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q does not exist", ErrPatchPath, token)
+		}
+		return child, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	default:
+		return nil, fmt.Errorf("%w: cannot look up %q in a %s", ErrPatchPath, token, jsonKind(node))
+	}
+}
+
+// setChild replaces an existing member or array element
+func setChild(node interface{}, token string, value interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[token] = value
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		n[i] = value
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%w: cannot set %q in a %s", ErrPatchPath, token, jsonKind(node))
+	}
+}
+
+// arrayIndex parses an array index token, which must be below limit
+func arrayIndex(token string, limit int) (int, error) {
+	// RFC 6901 doesn't allow leading zeros or signs
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("%w: %q is not an array index", ErrPatchPath, token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i >= limit {
+		return 0, fmt.Errorf("%w: array index %s is out of range", ErrPatchPath, token)
+	}
+	return i, nil
+}
+
+// jsonKind names the JSON type of a decoded value for error messages
+func jsonKind(node interface{}) string {
+	switch node.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// jsonEqual compares two decoded JSON values the way RFC 6902's test
+// operation does: numbers by value, objects regardless of member order.
+// Numbers too large to compare cheaply are only equal if they're written
+// the same way
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okx := parseRat(av.String())
+		y, oky := parseRat(bv.String())
+		if !okx || !oky {
+			return av == bv
+		}
+		return x.Cmp(y) == 0
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			other, ok := bv[k]
+			if !ok || !jsonEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// deepCopy copies a decoded JSON value so a copy operation doesn't leave
+// two paths sharing one map or slice
+func deepCopy(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			m[k] = deepCopy(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(n))
+		for i, v := range n {
+			s[i] = deepCopy(v)
+		}
+		return s
+	default:
+		return node
+	}
+}
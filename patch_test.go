@@ -0,0 +1,191 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// these mostly come from the examples in RFC 6902 appendix A
+var jsonPatchTests = []struct {
+	name           string
+	doc            string
+	patch          string
+	expected       string
+	expectedErr    error
+	expectedStatus int
+}{
+	{name: "add member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, expected: `{"baz":"qux","foo":"bar"}`},
+	{name: "add array element", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, expected: `{"foo":["bar","qux","baz"]}`},
+	{name: "append", doc: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, expected: `{"foo":["bar",["abc","def"]]}`},
+	{name: "remove member", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, expected: `{"foo":"bar"}`},
+	{name: "remove element", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, expected: `{"foo":["bar","baz"]}`},
+	{name: "replace", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, expected: `{"baz":"boo","foo":"bar"}`},
+	{name: "move", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, expected: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+	{name: "move element", doc: `{"foo":["all","grass","cows","eat"]}`, patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, expected: `{"foo":["all","cows","eat","grass"]}`},
+	{name: "copy", doc: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, expected: `{"a":{"b":1},"c":{"b":2}}`},
+	{name: "test passes", doc: `{"baz":"qux","foo":["a",2,"c"]}`, patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`, expected: `{"baz":"qux","foo":["a",2,"c"]}`},
+	{name: "huge numbers by text", doc: `{"n":1e999999}`, patch: `[{"op":"test","path":"/n","value":1e999999}]`, expected: `{"n":1e999999}`},
+	{name: "huge numbers not parsed", doc: `{"n":1e999999}`, patch: `[{"op":"test","path":"/n","value":10e999998}]`, expectedErr: ErrPatchTestFailed, expectedStatus: http.StatusConflict},
+	{name: "escaped path", doc: `{"/":9,"~1":10}`, patch: `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, expected: `{"~1":10}`},
+	{name: "null value", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/foo","value":null}]`, expected: `{"foo":null}`},
+	{name: "test fails", doc: `{"baz":"qux"}`, patch: `[{"op":"test","path":"/baz","value":"bar"}]`, expectedErr: ErrPatchTestFailed, expectedStatus: http.StatusConflict},
+	{name: "missing member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`, expectedErr: ErrPatchPath, expectedStatus: http.StatusUnprocessableEntity},
+	{name: "index out of range", doc: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/2","value":"qux"}]`, expectedErr: ErrPatchPath, expectedStatus: http.StatusUnprocessableEntity},
+	{name: "leading zero", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"remove","path":"/foo/01"}]`, expectedErr: ErrPatchPath, expectedStatus: http.StatusUnprocessableEntity},
+	{name: "remove missing", doc: `{"foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, expectedErr: ErrPatchPath, expectedStatus: http.StatusUnprocessableEntity},
+	{name: "unknown op", doc: `{"foo":"bar"}`, patch: `[{"op":"delete","path":"/foo"}]`, expectedErr: ErrInvalidPatch, expectedStatus: http.StatusBadRequest},
+	{name: "missing value", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz"}]`, expectedErr: ErrInvalidPatch, expectedStatus: http.StatusBadRequest},
+	{name: "bad path", doc: `{"foo":"bar"}`, patch: `[{"op":"remove","path":"foo"}]`, expectedErr: ErrInvalidPatch, expectedStatus: http.StatusBadRequest},
+	{name: "move into child", doc: `{"a":{"b":1}}`, patch: `[{"op":"move","from":"/a","path":"/a/c"}]`, expectedErr: ErrInvalidPatch, expectedStatus: http.StatusBadRequest},
+}
+
+func TestTools_JSONPatchApply(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range jsonPatchTests {
+		var patch JSONPatch
+		if err := json.Unmarshal([]byte(e.patch), &patch); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		out, err := patch.Apply([]byte(e.doc))
+		if e.expectedErr == nil {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			} else if string(out) != e.expected {
+				t.Errorf("%s: expected %s but got %s", e.name, e.expected, out)
+			}
+			continue
+		}
+
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expectedErr, err)
+			continue
+		}
+
+		// and check the status code ErrorJSON sends
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+// these come from the examples in RFC 7396 appendix A
+var mergePatchTests = []struct {
+	doc      string
+	patch    string
+	expected string
+}{
+	{doc: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+	{doc: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+	{doc: `{"a":"b"}`, patch: `{"a":null}`, expected: `{}`},
+	{doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+	{doc: `{"a":["b"]}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+	{doc: `{"a":"c"}`, patch: `{"a":["b"]}`, expected: `{"a":["b"]}`},
+	{doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, expected: `{"a":{"b":"d"}}`},
+	{doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, expected: `{"a":[1]}`},
+	{doc: `["a","b"]`, patch: `["c","d"]`, expected: `["c","d"]`},
+	{doc: `{"a":"b"}`, patch: `["c"]`, expected: `["c"]`},
+	{doc: `{"a":"foo"}`, patch: `null`, expected: `null`},
+	{doc: `{"e":null}`, patch: `{"a":1}`, expected: `{"a":1,"e":null}`},
+	{doc: `[1,2]`, patch: `{"a":"b","c":null}`, expected: `{"a":"b"}`},
+	{doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, expected: `{"a":{"bb":{}}}`},
+}
+
+func TestTools_MergePatchApply(t *testing.T) {
+	for _, e := range mergePatchTests {
+		out, err := MergePatch(e.patch).Apply([]byte(e.doc))
+		if err != nil {
+			t.Errorf("%s + %s: %s", e.doc, e.patch, err)
+			continue
+		}
+		if string(out) != e.expected {
+			t.Errorf("%s + %s: expected %s but got %s", e.doc, e.patch, e.expected, out)
+		}
+	}
+}
+
+type patchTarget struct {
+	Name  string   `json:"name"`
+	Email string   `json:"email,omitempty"`
+	Tags  []string `json:"tags"`
+}
+
+func TestTools_ReadJSONPatch(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`[{"op":"replace","path":"/name","value":"jill"},{"op":"add","path":"/tags/-","value":"new"},{"op":"remove","path":"/email"}]`))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+
+	patch, err := testTools.ReadJSONPatch(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := patchTarget{Name: "jack", Email: "jack@example.com", Tags: []string{"old"}}
+	if err = patch.ApplyTo(&target); err != nil {
+		t.Fatal(err)
+	}
+
+	if target.Name != "jill" || target.Email != "" || strings.Join(target.Tags, ",") != "old,new" {
+		t.Errorf("wrong result: %+v", target)
+	}
+
+	// a broken patch is caught when it's read
+	req, _ = http.NewRequest("PATCH", "/", strings.NewReader(`[{"op":"jump","path":"/name"}]`))
+	if _, err = testTools.ReadJSONPatch(httptest.NewRecorder(), req); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("expected an invalid patch error but got %v", err)
+	}
+
+	// unknown members are ignored, as RFC 6902 asks
+	req, _ = http.NewRequest("PATCH", "/", strings.NewReader(`[{"op":"test","path":"/name","value":"jill","comment":"check first"}]`))
+	if _, err = testTools.ReadJSONPatch(httptest.NewRecorder(), req); err != nil {
+		t.Errorf("expected unknown members to be ignored but got %v", err)
+	}
+
+	// a patch that leaves the document unfit for the target is the
+	// client's mistake
+	patch = JSONPatch{{Op: "replace", Path: "/name", Value: json.RawMessage(`5`)}}
+	var p *Problem
+	if err = patch.ApplyTo(&target); !errors.As(err, &p) || p.Status != http.StatusUnprocessableEntity {
+		t.Errorf("expected a 422 but got %v", err)
+	}
+
+	// and it gets the usual ReadJson errors
+	req, _ = http.NewRequest("PATCH", "/", strings.NewReader(`[{"op":`))
+	if _, err = testTools.ReadJSONPatch(httptest.NewRecorder(), req); err == nil || err.Error() != "body contains badly-formed JSON" {
+		t.Errorf("expected the ReadJson error but got %v", err)
+	}
+}
+
+func TestTools_ReadMergePatch(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 100}
+
+	req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`{"name":"jill","email":null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+
+	patch, err := testTools.ReadMergePatch(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := patchTarget{Name: "jack", Email: "jack@example.com", Tags: []string{"old"}}
+	if err = patch.ApplyTo(&target); err != nil {
+		t.Fatal(err)
+	}
+	if target.Name != "jill" || target.Email != "" || len(target.Tags) != 1 {
+		t.Errorf("wrong result: %+v", target)
+	}
+
+	// the size limit applies
+	req, _ = http.NewRequest("PATCH", "/", strings.NewReader(`{"name":"`+strings.Repeat("a", 200)+`"}`))
+	if _, err = testTools.ReadMergePatch(httptest.NewRecorder(), req); err == nil || err.Error() != "body must not be larger than 100 bytes" {
+		t.Errorf("expected the size error but got %v", err)
+	}
+}
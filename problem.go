@@ -0,0 +1,179 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// problemMembers are the member names defined by RFC 7807. Extension
+// members may not use them
+var problemMembers = map[string]bool{
+	"type": true, "title": true, "status": true, "detail": true, "instance": true,
+}
+
+// Problem is an RFC 7807 problem details document. ErrorJSON sends one
+// of these, as application/problem+json, when ProblemJSON is set on
+// Tools. A *Problem is also an error, so handlers can return one
+// directly.
+type Problem struct {
+	// Type is a URI reference that identifies the problem type. It
+	// defaults to "about:blank"
+	Type string
+	// Title is a short summary of the problem type
+	Title string
+	// Status is the HTTP status code
+	Status int
+	// Detail explains this occurrence of the problem
+	Detail string
+	// Instance is a URI reference that identifies this occurrence
+	Instance string
+	// Extensions holds any additional members. They're written at the
+	// top level of the document, next to the standard members
+	Extensions map[string]interface{}
+
+	// message is Detail before translation, for the toolkit's own errors
+	message message
+}
+
+// ProblemDetailer is implemented by errors that know how to describe
+// themselves as problem details. ErrorJSON uses it for the status code
+// and, when ProblemJSON is set, for the document it sends. A nil
+// *Problem means the error has nothing to say after all
+type ProblemDetailer interface {
+	ProblemDetails() *Problem
+}
+
+// Error implements the error interface
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	if p.Title != "" {
+		return p.Title
+	}
+	return http.StatusText(p.Status)
+}
+
+// ProblemDetails implements ProblemDetailer
+func (p *Problem) ProblemDetails() *Problem {
+	return p
+}
+
+// MarshalJSON writes the standard members, skipping empty ones, with the
+// extension members alongside them
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		if problemMembers[k] {
+			return nil, fmt.Errorf("problem extension %q clashes with a standard member", k)
+		}
+		doc[k] = v
+	}
+
+	doc["type"] = "about:blank"
+	if p.Type != "" {
+		doc["type"] = p.Type
+	}
+	if p.Title != "" {
+		doc["title"] = p.Title
+	}
+	if p.Status != 0 {
+		doc["status"] = p.Status
+	}
+	if p.Detail != "" {
+		doc["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		doc["instance"] = p.Instance
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON reads a problem document, putting any non-standard
+// members into Extensions
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	*p = Problem{}
+	fields := map[string]interface{}{
+		"type": &p.Type, "title": &p.Title, "status": &p.Status, "detail": &p.Detail, "instance": &p.Instance,
+	}
+	for k, raw := range doc {
+		if target, ok := fields[k]; ok {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return fmt.Errorf("problem member %q: %w", k, err)
+			}
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]interface{})
+		}
+		p.Extensions[k] = v
+	}
+	return nil
+}
+
+// ProblemDetails describes a validation error as a 422 problem with the
+// failing fields in an errors extension member
+func (v *ValidationError) ProblemDetails() *Problem {
+	title := v.title
+	if title == "" {
+		title = msg("validation.title").String()
+	}
+	return &Problem{
+		Title:      title,
+		Status:     http.StatusUnprocessableEntity,
+		Detail:     v.Error(),
+		Extensions: map[string]interface{}{"errors": v.Fields()},
+	}
+}
+
+// badRequest builds the 400 error returned for a body we can't use. Its
+// message is written for the client, so ErrorJSON shows it even in
+// production mode. code picks the message from the catalogs
+func badRequest(code string, args ...interface{}) error {
+	m := msg(code, args...)
+	return &Problem{Status: http.StatusBadRequest, Detail: m.String(), message: m}
+}
+
+// writeProblem sends an application/problem+json document. If the error
+// supplied its own problem details they're used as the starting point;
+// anything missing is filled in from the status code and detail. extra
+// members, such as the error code, are added to the extensions
+func (t *Tools) writeProblem(w http.ResponseWriter, statusCode int, detail string, supplied *Problem, extra map[string]interface{}) error {
+	var problem Problem
+	if supplied != nil {
+		// copy it, so we never change the error's own value
+		problem = *supplied
+	}
+
+	problem.Status = statusCode
+	if problem.Title == "" {
+		problem.Title = http.StatusText(statusCode)
+	}
+	if problem.Detail == "" {
+		problem.Detail = detail
+	}
+
+	if len(extra) > 0 {
+		extensions := make(map[string]interface{}, len(problem.Extensions)+len(extra))
+		for k, v := range problem.Extensions {
+			extensions[k] = v
+		}
+		for k, v := range extra {
+			extensions[k] = v
+		}
+		problem.Extensions = extensions
+	}
+
+	return t.writeJSON(w, nil, statusCode, &problem, "application/problem+json")
+}
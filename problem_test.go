@@ -0,0 +1,107 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var problemTests = []struct {
+	name           string
+	err            error
+	status         []int
+	expectedStatus int
+	expectedTitle  string
+	expectedDetail string
+	expectedType   string
+}{
+	{name: "plain error", err: errors.New("some error"), expectedStatus: http.StatusBadRequest, expectedTitle: "Bad Request", expectedDetail: "some error", expectedType: "about:blank"},
+	{name: "plain error with status", err: errors.New("some error"), status: []int{http.StatusServiceUnavailable}, expectedStatus: http.StatusServiceUnavailable, expectedTitle: "Service Unavailable", expectedDetail: "some error", expectedType: "about:blank"},
+	{name: "problem error", err: &Problem{Type: "https://example.com/out-of-credit", Title: "You do not have enough credit", Status: http.StatusForbidden, Detail: "balance is 30", Instance: "/account/12345"}, expectedStatus: http.StatusForbidden, expectedTitle: "You do not have enough credit", expectedDetail: "balance is 30", expectedType: "https://example.com/out-of-credit"},
+	{name: "wrapped problem error", err: fmt.Errorf("wrapped: %w", &Problem{Status: http.StatusConflict}), expectedStatus: http.StatusConflict, expectedTitle: "Conflict", expectedDetail: "wrapped: Conflict", expectedType: "about:blank"},
+	{name: "validation error", err: &ValidationError{Errors: []FieldError{{Field: "name", Rule: "required", Reason: "is required"}}}, expectedStatus: http.StatusUnprocessableEntity, expectedTitle: "Validation failed", expectedDetail: "validation failed: name: is required", expectedType: "about:blank"},
+}
+
+func TestTools_ErrorJSONProblem(t *testing.T) {
+	testTools := Tools{ProblemJSON: true}
+
+	for _, e := range problemTests {
+		rr := httptest.NewRecorder()
+		if err := testTools.ErrorJSON(rr, e.err, e.status...); err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: wrong content type %s", e.name, ct)
+		}
+
+		var p Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Errorf("%s: error decoding problem: %s", e.name, err)
+			continue
+		}
+
+		if p.Status != e.expectedStatus || p.Title != e.expectedTitle || p.Detail != e.expectedDetail || p.Type != e.expectedType {
+			t.Errorf("%s: wrong problem document: %+v", e.name, p)
+		}
+	}
+}
+
+func TestTools_ProblemExtensions(t *testing.T) {
+	p := &Problem{
+		Title:      "Validation failed",
+		Status:     http.StatusUnprocessableEntity,
+		Instance:   "/users",
+		Extensions: map[string]interface{}{"balance": 30},
+	}
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// extension members sit at the top level of the document
+	var doc map[string]interface{}
+	_ = json.Unmarshal(out, &doc)
+	if doc["balance"] != float64(30) || doc["instance"] != "/users" {
+		t.Errorf("wrong document written: %s", out)
+	}
+	if _, ok := doc["detail"]; ok {
+		t.Error("empty members should be left out")
+	}
+
+	var back Problem
+	if err = json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Extensions["balance"] != float64(30) || back.Instance != "/users" {
+		t.Errorf("wrong problem decoded: %+v", back)
+	}
+
+	// extensions may not replace the standard members
+	p.Extensions["status"] = 200
+	if _, err = json.Marshal(p); err == nil {
+		t.Error("expected an error for an extension named status")
+	}
+}
+
+func TestTools_ErrorJSONProblemStatus(t *testing.T) {
+	// even without ProblemJSON, an error's own status is used
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, &Problem{Status: http.StatusConflict, Detail: "already exists"})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("wrong status code returned; expected 409, but got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("wrong content type %s", ct)
+	}
+}
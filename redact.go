@@ -0,0 +1,337 @@
+package toolkit
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces every value the toolkit scrubs
+const Redacted = "[REDACTED]"
+
+// DefaultRedactFields are the field name patterns used when
+// Tools.RedactFields is nil. A field matches when its name, lower cased
+// and without '_' or '-', contains a pattern, so "token" covers
+// access_token and refreshToken.
+var DefaultRedactFields = []string{
+	"password", "passwd", "secret", "token", "authorization", "apikey",
+	"privatekey", "cardnumber", "creditcard", "cvv", "cvc",
+}
+
+var (
+	// keyValuePattern finds key=value and "key": "value" pairs in text
+	keyValuePattern = regexp.MustCompile(`("?)([\w.-]+)("?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|[^\s,;&}\]]+)`)
+	// authorizationPattern finds the credentials in an Authorization
+	// header, whatever they look like
+	authorizationPattern = regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?(?:bearer|basic)\s+)[\w.~+/-]+=*`)
+	// bearerPattern finds bearer and basic tokens elsewhere. They're
+	// only redacted if they look like credentials, since "basic plan"
+	// is English
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+([\w.~+/-]+=*)`)
+	// cardPattern finds runs of 13 to 19 digits, allowing the usual
+	// spaces and dashes; cards are told apart with the Luhn check
+	cardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+)
+
+// Redact returns a copy of v that's safe to log: structs, maps and
+// slices become plain maps and slices, and the value of any field that
+// has a redact:"true" tag, or whose name matches RedactFields, is
+// replaced with [REDACTED]. Other strings are scrubbed the way
+// RedactString does it. For example
+//
+//	type Login struct {
+//		User     string `json:"user"`
+//		Password string `json:"password"`
+//		PIN      string `json:"pin" redact:"true"`
+//	}
+//
+// becomes map[pin:[REDACTED] password:[REDACTED] user:alice].
+func (t *Tools) Redact(v interface{}) interface{} {
+	return t.redactor().value(reflect.ValueOf(v))
+}
+
+// RedactString scrubs text, such as an error message, of the values of
+// sensitive key=value and "key": "value" pairs, bearer tokens and card
+// numbers
+func (t *Tools) RedactString(s string) string {
+	return t.redactor().text(s)
+}
+
+// redactor holds the normalized field patterns
+type redactor struct {
+	fields []string
+}
+
+// redactor returns the redactor for the patterns configured on Tools.
+// It's built on first use, since every log entry needs one
+func (t *Tools) redactor() redactor {
+	t.redactOnce.Do(func() {
+		fields := DefaultRedactFields
+		if t.RedactFields != nil {
+			fields = t.RedactFields
+		}
+
+		t.rd = redactor{fields: make([]string, 0, len(fields))}
+		for _, f := range fields {
+			if f = normalizeFieldName(f); f != "" {
+				t.rd.fields = append(t.rd.fields, f)
+			}
+		}
+	})
+	return t.rd
+}
+
+// sensitive reports whether name matches one of the patterns
+func (rd redactor) sensitive(name string) bool {
+	name = normalizeFieldName(name)
+	for _, f := range rd.fields {
+		if strings.Contains(name, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// value walks v, building a redacted copy
+func (rd redactor) value(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil
+	}
+
+	// errors are scrubbed as text, and types that know how to print
+	// themselves, such as time.Time, are kept as they are
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case error:
+			return rd.text(x.Error())
+		case json.Marshaler, encoding.TextMarshaler:
+			return x
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return rd.value(v.Elem())
+
+	case reflect.String:
+		return rd.text(v.String())
+
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		typ := v.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			sf := typ.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			name, ok := jsonFieldName(sf)
+			if !ok {
+				continue
+			}
+			if sf.Tag.Get("redact") == "true" || rd.sensitive(name) || rd.sensitive(sf.Name) {
+				out[name] = Redacted
+				continue
+			}
+			out[name] = rd.value(v.Field(i))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			if rd.sensitive(key) {
+				out[key] = Redacted
+				continue
+			}
+			out[key] = rd.value(iter.Value())
+		}
+		return out
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		// raw bytes are opaque; say how many rather than print them
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprintf("[%d bytes]", v.Len())
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = rd.value(v.Index(i))
+		}
+		return out
+	}
+
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	return nil
+}
+
+// text scrubs a string
+func (rd redactor) text(s string) string {
+	s = authorizationPattern.ReplaceAllString(s, "${1}"+Redacted)
+	s = bearerPattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := bearerPattern.FindStringSubmatch(match)
+		if m[2] == Redacted || !looksLikeCredential(m[2]) {
+			return match
+		}
+		return m[1] + " " + Redacted
+	})
+
+	s = keyValuePattern.ReplaceAllStringFunc(s, func(pair string) string {
+		m := keyValuePattern.FindStringSubmatch(pair)
+		if !rd.sensitive(m[2]) {
+			// the value may hold pairs of its own, e.g. a URL with
+			// ?token=...
+			return m[1] + m[2] + m[3] + rd.text(m[4])
+		}
+		if strings.EqualFold(m[4], "bearer") || strings.EqualFold(m[4], "basic") {
+			// the credentials after the scheme are already redacted
+			return pair
+		}
+		value := Redacted
+		if strings.HasPrefix(m[4], `"`) {
+			value = `"` + Redacted + `"`
+		}
+		return m[1] + m[2] + m[3] + value
+	})
+
+	return cardPattern.ReplaceAllStringFunc(s, func(digits string) string {
+		if luhn(digits) {
+			return Redacted
+		}
+		return digits
+	})
+}
+
+// normalizeFieldName lower cases name and drops '_' and '-', so
+// api_key, api-key and apiKey all become apikey
+func normalizeFieldName(name string) string {
+	return fieldNameReplacer.Replace(strings.ToLower(name))
+}
+
+// fieldNameReplacer drops the separators normalizeFieldName ignores
+var fieldNameReplacer = strings.NewReplacer("_", "", "-", "")
+
+// looksLikeCredential reports whether a token has the shape of a key,
+// a JWT or base64 rather than a word: it's at least 8 characters long
+// and has a digit, an upper case letter after the first character, or
+// one of . + / =
+func looksLikeCredential(token string) bool {
+	if len(token) < 8 {
+		return false
+	}
+	for i, c := range token {
+		switch {
+		case c >= '0' && c <= '9', c == '.', c == '+', c == '/', c == '=':
+			return true
+		case i > 0 && c >= 'A' && c <= 'Z':
+			return true
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits in s pass the Luhn check used by card
+// numbers. Spaces and dashes are ignored
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// redactingLogger scrubs entries before passing them on
+type redactingLogger struct {
+	next Logger
+	rd   redactor
+}
+
+func (l redactingLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l redactingLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l redactingLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l redactingLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// log scrubs an entry and passes it on. A StdLogger is told about this
+// wrapper's frames, so its file and line point at the toolkit code that
+// logged the entry
+func (l redactingLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	msg, keyvals = l.rd.text(msg), l.scrub(keyvals)
+	if std, ok := l.next.(*StdLogger); ok {
+		std.log(4, level, msg, keyvals)
+		return
+	}
+
+	switch level {
+	case LevelDebug:
+		l.next.Debug(msg, keyvals...)
+	case LevelInfo:
+		l.next.Info(msg, keyvals...)
+	case LevelWarn:
+		l.next.Warn(msg, keyvals...)
+	default:
+		l.next.Error(msg, keyvals...)
+	}
+}
+
+// scrub redacts the values of sensitive keys and scrubs the rest
+func (l redactingLogger) scrub(keyvals []interface{}) []interface{} {
+	out := make([]interface{}, len(keyvals))
+	for i := 0; i < len(keyvals); i += 2 {
+		out[i] = keyvals[i]
+		if i+1 == len(keyvals) {
+			break
+		}
+		if key, ok := keyvals[i].(string); ok && l.rd.sensitive(key) {
+			out[i+1] = Redacted
+			continue
+		}
+
+		v := reflect.ValueOf(keyvals[i+1])
+		switch v.Kind() {
+		case reflect.String, reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr, reflect.Interface:
+			out[i+1] = l.rd.value(v)
+		default:
+			// numbers, durations and the like are kept as they are
+			out[i+1] = keyvals[i+1]
+		}
+	}
+	return out
+}
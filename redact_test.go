@@ -0,0 +1,135 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type redactCard struct {
+	Number string `json:"card_number"`
+	Expiry string `json:"expiry"`
+}
+
+type redactUser struct {
+	Name     string            `json:"name"`
+	Password string            `json:"password"`
+	PIN      string            `json:"pin" redact:"true"`
+	Card     *redactCard       `json:"card"`
+	Cards    []redactCard      `json:"cards"`
+	Headers  map[string]string `json:"headers"`
+	Notes    []string          `json:"notes"`
+	Joined   time.Time         `json:"joined"`
+	Hidden   string            `json:"-"`
+}
+
+func TestTools_Redact(t *testing.T) {
+	var testTools Tools
+
+	user := redactUser{
+		Name:     "alice",
+		Password: "hunter2",
+		PIN:      "1234",
+		Card:     &redactCard{Number: "4111111111111111", Expiry: "12/30"},
+		Cards:    []redactCard{{Number: "5500005555555559", Expiry: "01/29"}},
+		Headers:  map[string]string{"Authorization": "Bearer abc", "Accept": "application/json"},
+		Notes:    []string{"call me", "card 4111 1111 1111 1111 on file"},
+		Joined:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Hidden:   "x",
+	}
+
+	out, err := json.Marshal(testTools.Redact(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"card":{"card_number":"[REDACTED]","expiry":"12/30"},` +
+		`"cards":[{"card_number":"[REDACTED]","expiry":"01/29"}],` +
+		`"headers":{"Accept":"application/json","Authorization":"[REDACTED]"},` +
+		`"joined":"2024-01-02T00:00:00Z","name":"alice",` +
+		`"notes":["call me","card [REDACTED] on file"],"password":"[REDACTED]","pin":"[REDACTED]"}`
+	if string(out) != expected {
+		t.Errorf("wrong redaction:\nexpected %s\ngot      %s", expected, out)
+	}
+
+	// the original is untouched
+	if user.Password != "hunter2" || user.Card.Number != "4111111111111111" {
+		t.Error("Redact changed its argument")
+	}
+}
+
+var redactStringTests = []struct {
+	name     string
+	input    string
+	expected string
+}{
+	{name: "plain", input: "body must not be empty", expected: "body must not be empty"},
+	{name: "key value", input: "login failed for user=bob password=hunter2", expected: "login failed for user=bob password=[REDACTED]"},
+	{name: "json", input: `bad body {"api_key": "k-123", "id": 7}`, expected: `bad body {"api_key": "[REDACTED]", "id": 7}`},
+	{name: "url", input: "GET http://example.com/x?id=1&access_token=abc failed", expected: "GET http://example.com/x?id=1&access_token=[REDACTED] failed"},
+	{name: "bearer", input: "header was Bearer eyJhbGciOi.abc", expected: "header was Bearer [REDACTED]"},
+	{name: "authorization header", input: "sent Authorization: Basic dXNlcjpwYXNz", expected: "sent Authorization: Basic [REDACTED]"},
+	{name: "short authorization", input: `{"authorization": "bearer abc"}`, expected: `{"authorization": "[REDACTED]"}`},
+	{name: "basic english", input: "basic plan does not include exports", expected: "basic plan does not include exports"},
+	{name: "card", input: "charge 4111-1111-1111-1111 declined", expected: "charge [REDACTED] declined"},
+	{name: "not a card", input: "order 1234567890123 shipped", expected: "order 1234567890123 shipped"},
+}
+
+func TestTools_RedactString(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range redactStringTests {
+		if got := testTools.RedactString(e.input); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+
+	// custom patterns replace the defaults
+	custom := Tools{RedactFields: []string{"email"}}
+	if got := custom.RedactString("email=a@b.c password=x"); got != "email=[REDACTED] password=x" {
+		t.Errorf("custom fields: got %q", got)
+	}
+}
+
+func TestTools_RedactLogsAndErrors(t *testing.T) {
+	logger := &testLogger{}
+	testTools := Tools{Logger: logger}
+
+	testTools.logger().Info("login", "password", "hunter2", "user", redactUser{Name: "bob", Password: "hunter2"},
+		"error", errors.New("token=abc rejected"))
+	entry := fmt.Sprint(logger.entries)
+	if strings.Contains(entry, "hunter2") || strings.Contains(entry, "abc") || !strings.Contains(entry, "bob") {
+		t.Errorf("log entry not redacted: %s", entry)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, fmt.Errorf("card 4111111111111111 declined: secret=s3"))
+	var payload JSONResponse
+	_ = json.NewDecoder(rr.Body).Decode(&payload)
+	if payload.Message != "card [REDACTED] declined: secret=[REDACTED]" {
+		t.Errorf("error message not redacted: %q", payload.Message)
+	}
+
+	// validation messages name fields, not values, and are left alone
+	rr = httptest.NewRecorder()
+	ve := &ValidationError{}
+	ve.add("password", "min", msg("validation.min_length", 8))
+	_ = testTools.ErrorJSON(rr, ve)
+	_ = json.NewDecoder(rr.Body).Decode(&payload)
+	if payload.Message != "validation failed: password: must have length at least 8" {
+		t.Errorf("validation message changed: %q", payload.Message)
+	}
+
+	// and so are details the application wrote for the client
+	rr = httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, &Problem{Status: http.StatusNotFound, Detail: "order 4111111111111111 not found"})
+	_ = json.NewDecoder(rr.Body).Decode(&payload)
+	if payload.Message != "order 4111111111111111 not found" {
+		t.Errorf("application detail changed: %q", payload.Message)
+	}
+}
@@ -0,0 +1,300 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteError is returned by PushJSONToRemote when the remote side
+// answers with a status outside 2xx. It keeps the status, headers and
+// body so the caller can decide what to do with them.
+type RemoteError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Error includes the start of the body, which is usually where the
+// remote side explains itself
+func (e *RemoteError) Error() string {
+	msg := fmt.Sprintf("remote returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if body := strings.TrimSpace(string(e.Body)); body != "" {
+		if len(body) > 200 {
+			body = body[:200] + "..."
+		}
+		msg += ": " + body
+	}
+	return msg
+}
+
+// ProblemDetails reports a failing remote as a 502, so ErrorJSON doesn't
+// pass the remote's status, or its body, on to our own clients
+func (e *RemoteError) ProblemDetails() *Problem {
+	return &Problem{
+		Status: http.StatusBadGateway,
+		Detail: fmt.Sprintf("upstream service returned %d", e.StatusCode),
+	}
+}
+
+// logURL strips the user info, query string and fragment from uri, any
+// of which may hold credentials, so it can be logged
+func logURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	u.User, u.RawQuery, u.Fragment, u.ForceQuery = nil, "", "", false
+	return u.String()
+}
+
+// logError is err with the URL net/http puts in its errors cut down by
+// logURL
+func logError(err error) error {
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		safe := *urlError
+		safe.URL = logURL(urlError.URL)
+		return &safe
+	}
+	return err
+}
+
+// PushJSONToRemote sends data as JSON to uri with the given method,
+// usually POST or PUT, and decodes a JSON response into out, which may
+// be nil if the response doesn't matter. It returns the status code of
+// the last response, or 0 if there wasn't one.
+//
+// Each attempt is limited to RemoteTimeout, as well as by ctx. Requests
+// with an idempotent method (GET, HEAD, PUT, DELETE, OPTIONS) are retried
+// up to RemoteRetries times on network errors and on 429, 502, 503 and
+// 504, waiting RemoteBackoff, doubled on each retry and with jitter, or
+// whatever Retry-After asks for, up to RemoteMaxRetryWait. POST and PATCH are never retried, since
+// the remote side may already have acted on them. Any response outside
+// 2xx comes back as a *RemoteError. If CircuitBreaker is set, calls to
+// a host whose circuit is open fail at once with a *CircuitOpenError.
+// If WebhookSigner is set, every attempt is signed. A request ID in ctx
+// is forwarded in the X-Request-ID header.
+func (t *Tools) PushJSONToRemote(ctx context.Context, method, uri string, data, out interface{}, headers ...http.Header) (int, error) {
+	client := http.DefaultClient
+	if t.HTTPClient != nil {
+		client = t.HTTPClient
+	}
+
+	timeout := 10 * time.Second
+	if t.RemoteTimeout != 0 {
+		timeout = t.RemoteTimeout
+	}
+
+	retries := 2
+	if t.RemoteRetries != 0 {
+		retries = t.RemoteRetries
+	}
+	if retries < 0 || !isIdempotent(method) {
+		retries = 0
+	}
+
+	backoff := 100 * time.Millisecond
+	if t.RemoteBackoff != 0 {
+		backoff = t.RemoteBackoff
+	}
+	maxWait := timeout
+	if t.RemoteMaxRetryWait != 0 {
+		maxWait = t.RemoteMaxRetryWait
+	}
+
+	// the body is marshalled once and replayed on each attempt
+	var payload []byte
+	if data != nil {
+		var err error
+		payload, err = json.Marshal(data)
+		if err != nil {
+			return 0, fmt.Errorf("error marshalling JSON: %w", err)
+		}
+	}
+
+	reqID := RequestIDFromContext(ctx)
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		statusCode, wait, err := t.pushOnce(ctx, client, timeout, method, uri, payload, out, headers...)
+		if err != nil {
+			t.logger().Warn("remote call failed", "request_id", reqID, "method", method, "url", logURL(uri), "attempt", attempt+1,
+				"status", statusCode, "duration", time.Since(start), "error", logError(err))
+		} else {
+			t.logger().Info("remote call", "request_id", reqID, "method", method, "url", logURL(uri), "attempt", attempt+1,
+				"status", statusCode, "size", len(payload), "duration", time.Since(start))
+		}
+		if err == nil || attempt >= retries || !retryable(ctx, statusCode, err) {
+			return statusCode, err
+		}
+
+		if wait > maxWait {
+			// the remote side won't be ready in any time we'd wait
+			return statusCode, err
+		}
+		if wait == 0 {
+			wait = jitter(backoff << attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return statusCode, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pushOnce makes a single attempt. It returns the status code, how long
+// the remote side asked us to wait in Retry-After, and any error
+func (t *Tools) pushOnce(parent context.Context, client *http.Client, timeout time.Duration, method, uri string, payload []byte, out interface{}, headers ...http.Header) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, h := range headers {
+		for key, value := range h {
+			req.Header[key] = value
+		}
+	}
+	if payload != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if id := RequestIDFromContext(ctx); id != "" && req.Header.Get(RequestIDHeader) == "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if t.WebhookSigner != nil {
+		// each attempt gets a fresh timestamp, so retries aren't
+		// rejected as replays
+		for key, value := range t.WebhookSigner.Sign(payload, time.Now()) {
+			req.Header[key] = value
+		}
+	}
+
+	var call CircuitCall
+	if t.CircuitBreaker != nil {
+		if call, err = t.CircuitBreaker.Allow(req.URL.Host); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	resp, err := client.Do(req)
+	if t.CircuitBreaker != nil {
+		// the attempt's own timeout is the host's fault, the caller's
+		// ctx ending isn't
+		t.CircuitBreaker.finish(call, resp, err, parent.Err() != nil)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	maxBytes := t.maxJSONBytes()
+
+	// read one byte more than allowed, so we can tell a body that's too
+	// big from one that's exactly the limit
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return resp.StatusCode, 0, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(raw) > maxBytes {
+			raw = raw[:maxBytes]
+		}
+		return resp.StatusCode, retryAfter(resp.Header), &RemoteError{StatusCode: resp.StatusCode, Header: resp.Header, Body: raw}
+	}
+
+	if len(raw) > maxBytes {
+		return resp.StatusCode, 0, fmt.Errorf("response must not be larger than %d bytes", maxBytes)
+	}
+	if out == nil || len(bytes.TrimSpace(raw)) == 0 {
+		return resp.StatusCode, 0, nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return resp.StatusCode, 0, fmt.Errorf("error decoding JSON response: %w", err)
+	}
+	return resp.StatusCode, 0, nil
+}
+
+// isIdempotent reports whether repeating a request with method is safe
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether a failed attempt is worth repeating. A
+// cancelled or expired ctx is not, but a single attempt timing out is
+func retryable(ctx context.Context, statusCode int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	// an open circuit won't close before our next attempt
+	var openError *CircuitOpenError
+	if errors.As(err, &openError) {
+		return false
+	}
+
+	var remoteError *RemoteError
+	if errors.As(err, &remoteError) {
+		switch statusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	// anything that got a response, but not a usable one, is not a
+	// network problem
+	return statusCode == 0
+}
+
+// retryAfter returns the wait asked for by a Retry-After header, in
+// seconds or as a date, or 0 if there isn't one
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// jitter returns a random wait between half of d and d, so clients that
+// failed together don't all retry together
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(mathrand.Int63n(int64(half)+1))
+}
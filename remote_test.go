@@ -0,0 +1,151 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var pushTests = []struct {
+	name             string
+	method           string
+	statuses         []int
+	expectedStatus   int
+	expectedAttempts int32
+	errorExpected    bool
+}{
+	{name: "created", method: "POST", statuses: []int{201}, expectedStatus: 201, expectedAttempts: 1},
+	{name: "put retried", method: "PUT", statuses: []int{503, 502, 200}, expectedStatus: 200, expectedAttempts: 3},
+	{name: "put gives up", method: "PUT", statuses: []int{503, 503, 503, 503}, expectedStatus: 503, expectedAttempts: 3, errorExpected: true},
+	{name: "post not retried", method: "POST", statuses: []int{503, 200}, expectedStatus: 503, expectedAttempts: 1, errorExpected: true},
+	{name: "client error not retried", method: "PUT", statuses: []int{400, 200}, expectedStatus: 400, expectedAttempts: 1, errorExpected: true},
+}
+
+func TestTools_PushJSONToRemote(t *testing.T) {
+	testTools := Tools{RemoteBackoff: time.Millisecond}
+
+	for _, e := range pushTests {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&attempts, 1)
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != `{"name":"widget"}` || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s: wrong request received: %s %s", e.name, r.Header.Get("Content-Type"), body)
+			}
+
+			status := e.statuses[n-1]
+			w.WriteHeader(status)
+			if status < 300 {
+				_, _ = w.Write([]byte(`{"id":7}`))
+			} else {
+				_, _ = w.Write([]byte(`{"error":"nope"}`))
+			}
+		}))
+
+		var out struct {
+			ID int `json:"id"`
+		}
+		status, err := testTools.PushJSONToRemote(context.Background(), e.method, srv.URL, map[string]string{"name": "widget"}, &out)
+		srv.Close()
+
+		if status != e.expectedStatus || attempts != e.expectedAttempts {
+			t.Errorf("%s: expected status %d after %d attempts but got %d after %d", e.name, e.expectedStatus, e.expectedAttempts, status, attempts)
+		}
+
+		if e.errorExpected {
+			var remoteError *RemoteError
+			if !errors.As(err, &remoteError) || remoteError.StatusCode != e.expectedStatus || string(remoteError.Body) != `{"error":"nope"}` {
+				t.Errorf("%s: expected a remote error but got %v", e.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error not expected but one received: %s", e.name, err)
+		}
+		if out.ID != 7 {
+			t.Errorf("%s: response not decoded: %+v", e.name, out)
+		}
+	}
+}
+
+func TestTools_PushJSONToRemoteTimeout(t *testing.T) {
+	testTools := Tools{RemoteTimeout: 20 * time.Millisecond, RemoteRetries: -1}
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	status, err := testTools.PushJSONToRemote(context.Background(), "GET", srv.URL, nil, nil)
+	if err == nil || status != 0 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout but got %d, %v", status, err)
+	}
+}
+
+func TestTools_PushJSONToRemoteRetryAfter(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// an hour is more than we'll wait, so we give up at once
+	testTools := Tools{RemoteMaxRetryWait: time.Second}
+	start := time.Now()
+	status, err := testTools.PushJSONToRemote(context.Background(), "GET", srv.URL, nil, nil)
+	var remoteError *RemoteError
+	if status != http.StatusServiceUnavailable || !errors.As(err, &remoteError) || attempts != 1 {
+		t.Errorf("expected one 503 but got %d, %v after %d attempts", status, err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s for Retry-After", elapsed)
+	}
+}
+
+func TestTools_RemoteErrorJSON(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, &RemoteError{StatusCode: 500, Body: []byte("db password=hunter2 at 10.0.0.1")})
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 but got %d", rr.Code)
+	}
+
+	// the remote body stays out of the response, production or not
+	var payload JSONResponse
+	_ = json.NewDecoder(rr.Body).Decode(&payload)
+	if payload.Message != "upstream service returned 500" {
+		t.Errorf("remote body passed on: %q", payload.Message)
+	}
+}
+
+func TestTools_PushJSONToRemoteLogsNoQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	logger := &testLogger{}
+	testTools := Tools{Logger: logger}
+	if _, err := testTools.PushJSONToRemote(context.Background(), "POST", srv.URL+"/hook?token=abc123", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, leaked := logger.find("abc123"); leaked {
+		t.Error("query string logged")
+	}
+	if _, ok := logger.find("remote call", srv.URL+"/hook"); !ok {
+		t.Errorf("expected the URL to be logged without its query: %v", logger.entries)
+	}
+}
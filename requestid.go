@@ -0,0 +1,73 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader is the header request IDs travel in, both ways
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key for the request ID
+type requestIDKey struct{}
+
+// RequestIDMiddleware gives every request an ID. An X-Request-ID sent by
+// the client or a proxy is kept if it looks sane; otherwise a random one
+// is made. The ID is stored in the request context, echoed in the
+// X-Request-ID response header, added to the toolkit's log entries, and
+// sent back by ErrorJSON in the request_id member, so a user quoting an
+// error can be matched to the server log:
+//
+//	mux := http.NewServeMux()
+//	...
+//	http.ListenAndServe(":8080", tools.RequestIDMiddleware(mux))
+func (t *Tools) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = randomID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// WithRequestID returns a copy of ctx carrying id. PushJSONToRemote
+// forwards the ID of its ctx, so a chain of services shares one ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored by
+// RequestIDMiddleware, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the ID of r for log entries: the one in its context,
+// then the one in its header, or an empty string
+func requestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get(RequestIDHeader)
+}
+
+// validRequestID accepts IDs of up to 128 visible ASCII characters, so a
+// client can't stuff our logs or headers with anything else
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var requestIDTests = []struct {
+	name     string
+	incoming string
+	keep     bool
+}{
+	{name: "generated", incoming: ""},
+	{name: "kept", incoming: "abc-123", keep: true},
+	{name: "too long", incoming: strings.Repeat("a", 129)},
+	{name: "control characters", incoming: "abc\x00def"},
+	{name: "spaces", incoming: "abc def"},
+}
+
+func TestTools_RequestIDMiddleware(t *testing.T) {
+	logger := &testLogger{}
+	testTools := Tools{Logger: logger, Production: true}
+
+	for _, e := range requestIDTests {
+		var seen string
+		handler := testTools.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestIDFromContext(r.Context())
+			_ = testTools.ErrorJSON(w, errors.New("open /var/data: permission denied"))
+		}))
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		if e.incoming != "" {
+			req.Header.Set(RequestIDHeader, e.incoming)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if seen == "" || rr.Header().Get(RequestIDHeader) != seen {
+			t.Errorf("%s: context has %q but header has %q", e.name, seen, rr.Header().Get(RequestIDHeader))
+		}
+		if e.keep != (seen == e.incoming) {
+			t.Errorf("%s: incoming ID %q handled wrongly, got %q", e.name, e.incoming, seen)
+		}
+
+		var payload JSONResponse
+		_ = json.NewDecoder(rr.Body).Decode(&payload)
+		if payload.RequestID != seen {
+			t.Errorf("%s: expected request_id %q in the error but got %q", e.name, seen, payload.RequestID)
+		}
+		if _, ok := logger.find("ERROR", "request_id "+seen, payload.CorrelationID); !ok {
+			t.Errorf("%s: hidden error not logged with the request ID: %v", e.name, logger.entries)
+		}
+	}
+}
+
+func TestTools_PushJSONToRemoteRequestID(t *testing.T) {
+	var forwarded string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+	}))
+	defer srv.Close()
+
+	var testTools Tools
+	ctx := WithRequestID(context.Background(), "chain-7")
+	if _, err := testTools.PushJSONToRemote(ctx, "GET", srv.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded != "chain-7" {
+		t.Errorf("expected the request ID to be forwarded but got %q", forwarded)
+	}
+}
+
+func TestJSONResponse_MarshalXMLRequestID(t *testing.T) {
+	payload := JSONResponse{Error: true, Message: "not found", RequestID: "abc-123"}
+
+	out, err := xml.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "<request_id>abc-123</request_id>") {
+		t.Errorf("no request_id element in %s", out)
+	}
+}
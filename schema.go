@@ -0,0 +1,481 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema. Only a subset of draft 2020-12 is
+// supported: type, enum, const, required, properties,
+// additionalProperties, items, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minLength, maxLength, minItems, maxItems and
+// pattern. Annotations, such as $schema, title and description, are
+// ignored. Any other keyword, e.g. $ref, oneOf or format, is an error
+// when the schema is compiled, rather than quietly letting everything
+// through.
+type Schema struct {
+	// boolean schemas: true accepts anything, false nothing
+	always *bool
+
+	types      []string
+	enum       []interface{}
+	constValue interface{}
+	hasConst   bool
+
+	required             []string
+	properties           map[string]*Schema
+	additionalProperties *Schema
+
+	items *Schema
+
+	minimum, maximum                   *big.Rat
+	exclusiveMinimum, exclusiveMaximum *big.Rat
+
+	minLength, maxLength int
+	minItems, maxItems   int
+	pattern              *regexp.Regexp
+}
+
+// schemaTypes are the values allowed for the type keyword
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// schemaKeywords are the keywords CompileSchema understands, and the
+// annotations it accepts and ignores
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "required": true,
+	"properties": true, "additionalProperties": true, "items": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"minLength": true, "maxLength": true, "minItems": true, "maxItems": true, "pattern": true,
+
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+// CompileSchema parses a JSON Schema document so it can be used to
+// validate request bodies
+func CompileSchema(doc []byte) (*Schema, error) {
+	node, err := decodeDocument(doc)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return compileSchema(node, "")
+}
+
+// MustCompileSchema is like CompileSchema but panics if the schema is
+// broken. It's meant for package level variables
+func MustCompileSchema(doc []byte) *Schema {
+	s, err := CompileSchema(doc)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// compileSchema builds a Schema from a decoded schema document. pointer
+// is its location, for error messages
+func compileSchema(node interface{}, pointer string) (*Schema, error) {
+	fail := func(keyword, format string, args ...interface{}) (*Schema, error) {
+		return nil, fmt.Errorf("schema: %s/%s: %s", pointer, keyword, fmt.Sprintf(format, args...))
+	}
+
+	if b, ok := node.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	doc, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema: %s: must be an object or a boolean", pointer)
+	}
+
+	// sorted, so the error is the same every time
+	keywords := make([]string, 0, len(doc))
+	for keyword := range doc {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		if !schemaKeywords[keyword] {
+			return fail(keyword, "keyword is not supported")
+		}
+	}
+
+	s := &Schema{minLength: -1, maxLength: -1, minItems: -1, maxItems: -1}
+
+	switch v := doc["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{v}
+	case []interface{}:
+		for _, t := range v {
+			name, ok := t.(string)
+			if !ok {
+				return fail("type", "must be a string or an array of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return fail("type", "must be a string or an array of strings")
+	}
+	for _, t := range s.types {
+		if !schemaTypes[t] {
+			return fail("type", "unknown type %q", t)
+		}
+	}
+
+	if v, ok := doc["enum"]; ok {
+		values, ok := v.([]interface{})
+		if !ok {
+			return fail("enum", "must be an array")
+		}
+		s.enum = values
+	}
+	if v, ok := doc["const"]; ok {
+		s.constValue, s.hasConst = v, true
+	}
+
+	if v, ok := doc["required"]; ok {
+		names, ok := v.([]interface{})
+		if !ok {
+			return fail("required", "must be an array of strings")
+		}
+		for _, n := range names {
+			name, ok := n.(string)
+			if !ok {
+				return fail("required", "must be an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+
+	if v, ok := doc["properties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("properties", "must be an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			compiled, err := compileSchema(sub, pointer+"/properties/"+escapePointer(name))
+			if err != nil {
+				return nil, err
+			}
+			s.properties[name] = compiled
+		}
+	}
+
+	if v, ok := doc["additionalProperties"]; ok {
+		compiled, err := compileSchema(v, pointer+"/additionalProperties")
+		if err != nil {
+			return nil, err
+		}
+		s.additionalProperties = compiled
+	}
+
+	if v, ok := doc["items"]; ok {
+		compiled, err := compileSchema(v, pointer+"/items")
+		if err != nil {
+			return nil, err
+		}
+		s.items = compiled
+	}
+
+	numbers := map[string]**big.Rat{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+	}
+	for keyword, target := range numbers {
+		if v, ok := doc[keyword]; ok {
+			n, ok := v.(json.Number)
+			if !ok {
+				return fail(keyword, "must be a number")
+			}
+			r, ok := new(big.Rat).SetString(n.String())
+			if !ok {
+				return fail(keyword, "must be a number")
+			}
+			*target = r
+		}
+	}
+
+	counts := map[string]*int{
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+	}
+	for keyword, target := range counts {
+		if v, ok := doc[keyword]; ok {
+			n, ok := v.(json.Number)
+			if !ok {
+				return fail(keyword, "must be a non-negative integer")
+			}
+			i, err := strconv.Atoi(n.String())
+			if err != nil || i < 0 {
+				return fail(keyword, "must be a non-negative integer")
+			}
+			*target = i
+		}
+	}
+
+	if v, ok := doc["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return fail("pattern", "must be a string")
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fail("pattern", "%s", err)
+		}
+		s.pattern = re
+	}
+
+	return s, nil
+}
+
+// Validate checks v against the schema and returns a *ValidationError
+// listing every violation, keyed by JSON Pointer. v may be any value
+// that can be marshaled to JSON
+func (s *Schema) Validate(v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.ValidateJSON(raw)
+}
+
+// ValidateJSON checks a raw JSON document against the schema and returns
+// a *ValidationError listing every violation, keyed by JSON Pointer
+func (s *Schema) ValidateJSON(raw []byte) error {
+	node, err := decodeDocument(raw)
+	if err != nil {
+		return err
+	}
+
+	var ve ValidationError
+	s.validate(node, "", &ve)
+	if len(ve.Errors) > 0 {
+		return &ve
+	}
+	return nil
+}
+
+// validate checks node, found at pointer, and records any violations
+func (s *Schema) validate(node interface{}, pointer string, ve *ValidationError) {
+	if s.always != nil {
+		if !*s.always {
+			ve.add(pointer, "false", msg("validation.not_allowed"))
+		}
+		return
+	}
+
+	if len(s.types) > 0 && !s.matchesType(node) {
+		ve.add(pointer, "type", msg("validation.type", strings.Join(s.types, " or ")))
+		// the other keywords make no sense for the wrong type
+		return
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, option := range s.enum {
+			if jsonEqual(node, option) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ve.add(pointer, "enum", msg("validation.oneof", describeValues(s.enum)))
+		}
+	}
+	if s.hasConst && !jsonEqual(node, s.constValue) {
+		ve.add(pointer, "const", msg("validation.const", describeValues([]interface{}{s.constValue})))
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		s.validateObject(n, pointer, ve)
+	case []interface{}:
+		s.validateArray(n, pointer, ve)
+	case string:
+		s.validateString(n, pointer, ve)
+	case json.Number:
+		s.validateNumber(n, pointer, ve)
+	}
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, pointer string, ve *ValidationError) {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			ve.add(pointer+"/"+escapePointer(name), "required", msg("validation.required"))
+		}
+	}
+
+	// sort the names so errors come out in a stable order
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := pointer + "/" + escapePointer(name)
+		if sub, ok := s.properties[name]; ok {
+			sub.validate(obj[name], child, ve)
+			continue
+		}
+		if s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				ve.add(child, "additionalProperties", msg("validation.not_allowed_property"))
+				continue
+			}
+			s.additionalProperties.validate(obj[name], child, ve)
+		}
+	}
+}
+
+func (s *Schema) validateArray(arr []interface{}, pointer string, ve *ValidationError) {
+	if s.minItems >= 0 && len(arr) < s.minItems {
+		ve.add(pointer, "minItems", msg("validation.min_items", s.minItems))
+	}
+	if s.maxItems >= 0 && len(arr) > s.maxItems {
+		ve.add(pointer, "maxItems", msg("validation.max_items", s.maxItems))
+	}
+	if s.items != nil {
+		for i, item := range arr {
+			s.items.validate(item, pointer+"/"+strconv.Itoa(i), ve)
+		}
+	}
+}
+
+func (s *Schema) validateString(str, pointer string, ve *ValidationError) {
+	// lengths are counted in characters, not bytes
+	length := utf8.RuneCountInString(str)
+	if s.minLength >= 0 && length < s.minLength {
+		ve.add(pointer, "minLength", msg("validation.min_length", s.minLength))
+	}
+	if s.maxLength >= 0 && length > s.maxLength {
+		ve.add(pointer, "maxLength", msg("validation.max_length", s.maxLength))
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		ve.add(pointer, "pattern", msg("validation.pattern", s.pattern.String()))
+	}
+}
+
+func (s *Schema) validateNumber(n json.Number, pointer string, ve *ValidationError) {
+	if s.minimum == nil && s.maximum == nil && s.exclusiveMinimum == nil && s.exclusiveMaximum == nil {
+		return
+	}
+	value, ok := parseRat(n.String())
+	if !ok {
+		ve.add(pointer, "type", msg("validation.number_bounds", maxNumberDigits, maxNumberExponent))
+		return
+	}
+
+	if s.minimum != nil && value.Cmp(s.minimum) < 0 {
+		ve.add(pointer, "minimum", msg("validation.min", formatRat(s.minimum)))
+	}
+	if s.maximum != nil && value.Cmp(s.maximum) > 0 {
+		ve.add(pointer, "maximum", msg("validation.max", formatRat(s.maximum)))
+	}
+	if s.exclusiveMinimum != nil && value.Cmp(s.exclusiveMinimum) <= 0 {
+		ve.add(pointer, "exclusiveMinimum", msg("validation.greater_than", formatRat(s.exclusiveMinimum)))
+	}
+	if s.exclusiveMaximum != nil && value.Cmp(s.exclusiveMaximum) >= 0 {
+		ve.add(pointer, "exclusiveMaximum", msg("validation.less_than", formatRat(s.exclusiveMaximum)))
+	}
+}
+
+// matchesType reports whether node is one of the schema's types
+func (s *Schema) matchesType(node interface{}) bool {
+	for _, t := range s.types {
+		switch n := node.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			// 1.0 counts as an integer too. Numbers too large to parse
+			// cheaply don't
+			if t != "integer" {
+				continue
+			}
+			if r, ok := parseRat(n.String()); ok && r.IsInt() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatRat prints a schema limit as a decimal. The limits come from
+// decimal JSON numbers, so they always have a finite expansion
+func formatRat(r *big.Rat) string {
+	if r.IsInt() {
+		return r.RatString()
+	}
+	return strings.TrimRight(r.FloatString(30), "0")
+}
+
+// describeValues formats enum options for an error message
+func describeValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		out, _ := json.Marshal(v)
+		parts[i] = string(out)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// escapePointer escapes a member name for use in a JSON Pointer
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// ReadJSONWithSchema works like ReadJson, but checks the body against a
+// JSON Schema before decoding it into data. Violations come back as a
+// *ValidationError keyed by JSON Pointer, which ErrorJSON sends as a
+// 422. data may be nil if the caller only wants the body checked.
+func (t *Tools) ReadJSONWithSchema(w http.ResponseWriter, r *http.Request, schema *Schema, data interface{}) (err error) {
+	defer func() { err = t.LocalizeError(r, err) }()
+
+	maxBytes := t.maxJSONBytes()
+
+	// ReadJson does the limits, content type and syntax checks for us
+	var raw json.RawMessage
+	if err := t.ReadJson(w, r, &raw); err != nil {
+		return err
+	}
+
+	if err := schema.ValidateJSON(raw); err != nil {
+		return err
+	}
+
+	if data == nil {
+		return nil
+	}
+	return t.decodeValue(raw, data, maxBytes)
+}
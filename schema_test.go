@@ -0,0 +1,145 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var userSchema = MustCompileSchema([]byte(`{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 10},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"score": {"type": ["number", "null"], "maximum": 10.5},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}},
+		"a/b": {"const": 1}
+	}
+}`))
+
+var schemaTests = []struct {
+	name     string
+	json     string
+	expected map[string]string
+}{
+	{name: "valid", json: `{"name":"jack","age":30,"email":"jack@example.com","role":"admin","score":null,"tags":["a"]}`, expected: nil},
+	{name: "integer as float", json: `{"name":"jack","age":30.0}`, expected: nil},
+	{name: "wrong root type", json: `[1]`, expected: map[string]string{"": "must be of type object"}},
+	{name: "missing required", json: `{}`, expected: map[string]string{"/name": "is required", "/age": "is required"}},
+	{name: "wrong types", json: `{"name":1,"age":1.5}`, expected: map[string]string{"/name": "must be of type string", "/age": "must be of type integer"}},
+	{name: "ranges", json: `{"name":"j","age":150,"score":10.6}`, expected: map[string]string{"/name": "must have length at least 2", "/age": "must be less than 150", "/score": "must be at most 10.5"}},
+	{name: "pattern and enum", json: `{"name":"jack","age":1,"email":"nope","role":"root"}`, expected: map[string]string{"/email": "must match the pattern ^[^@]+@[^@]+$", "/role": `must be one of: "admin", "user"`}},
+	{name: "array", json: `{"name":"jack","age":1,"tags":["a","","c"]}`, expected: map[string]string{"/tags": "must have at most 2 items", "/tags/1": "must have length at least 1"}},
+	{name: "additional property", json: `{"name":"jack","age":1,"admin":true}`, expected: map[string]string{"/admin": "is not an allowed property"}},
+	{name: "escaped pointer", json: `{"name":"jack","age":1,"a/b":2}`, expected: map[string]string{"/a~1b": "must be 1"}},
+	{name: "huge numbers", json: `{"name":"jack","age":1e999999,"score":1e999999}`, expected: map[string]string{"/age": "must be of type integer", "/score": "must not have more than 1000 digits or an exponent beyond ±1000"}},
+	{name: "characters not bytes", json: `{"name":"éé","age":1}`, expected: nil},
+}
+
+func TestTools_SchemaValidate(t *testing.T) {
+	for _, e := range schemaTests {
+		err := userSchema.ValidateJSON([]byte(e.json))
+		if e.expected == nil {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err)
+			}
+			continue
+		}
+
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%s: expected a validation error but got %v", e.name, err)
+			continue
+		}
+
+		fields := ve.Fields()
+		if len(fields) != len(e.expected) {
+			t.Errorf("%s: expected %d violations but got %d: %v", e.name, len(e.expected), len(fields), fields)
+		}
+		for k, v := range e.expected {
+			if fields[k] != v {
+				t.Errorf("%s: %q: expected %q but got %q", e.name, k, v, fields[k])
+			}
+		}
+	}
+}
+
+var badSchemaTests = []struct {
+	name   string
+	schema string
+}{
+	{name: "not an object", schema: `"string"`},
+	{name: "unknown type", schema: `{"type": "text"}`},
+	{name: "bad required", schema: `{"required": "name"}`},
+	{name: "bad minimum", schema: `{"minimum": "1"}`},
+	{name: "negative length", schema: `{"minLength": -1}`},
+	{name: "bad pattern", schema: `{"pattern": "("}`},
+	{name: "bad nested", schema: `{"properties": {"a": {"type": 1}}}`},
+	{name: "ref", schema: `{"$ref": "#/$defs/user"}`},
+	{name: "combinator", schema: `{"oneOf": [{"type": "string"}, {"type": "number"}]}`},
+	{name: "nested format", schema: `{"properties": {"email": {"type": "string", "format": "email"}}}`},
+}
+
+func TestTools_CompileSchemaErrors(t *testing.T) {
+	for _, e := range badSchemaTests {
+		if _, err := CompileSchema([]byte(e.schema)); err == nil {
+			t.Errorf("%s: error expected, but none received", e.name)
+		}
+	}
+}
+
+func TestTools_ReadJSONWithSchema(t *testing.T) {
+	var testTools Tools
+
+	var user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"name":"jack","age":30}`))
+	if err := testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, userSchema, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "jack" || user.Age != 30 {
+		t.Errorf("wrong value decoded: %+v", user)
+	}
+
+	// a body that breaks the schema is sent back as a 422
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(`{"name":"j"}`))
+	err := testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, userSchema, &user)
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code returned; expected 422, but got %d", rr.Code)
+	}
+
+	// and the usual ReadJson errors still apply
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(`{"name":`))
+	err = testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, userSchema, &user)
+	if err == nil || err.Error() != "body contains badly-formed JSON" {
+		t.Errorf("expected the ReadJson error but got %v", err)
+	}
+}
+
+func TestTools_SchemaHugeNumbersAreCheap(t *testing.T) {
+	schema := MustCompileSchema([]byte(`{"type":"array","items":{"type":"integer"}}`))
+	doc := "[" + strings.TrimSuffix(strings.Repeat("1e999999,", 200), ",") + "]"
+
+	start := time.Now()
+	err := schema.ValidateJSON([]byte(doc))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("validating took %s", elapsed)
+	}
+	var ve *ValidationError
+	if !errors.As(err, &ve) || len(ve.Errors) != 200 {
+		t.Errorf("expected every number to be rejected but got %v", err)
+	}
+}
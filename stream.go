@@ -0,0 +1,192 @@
+package toolkit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// StreamFormat is the layout used by the streaming writers
+type StreamFormat int
+
+const (
+	// StreamArray writes the items as a single JSON array
+	StreamArray StreamFormat = iota
+	// StreamNDJSON writes one JSON value per line (newline-delimited
+	// JSON), as application/x-ndjson
+	StreamNDJSON
+)
+
+// StreamJSON writes items to the client as they're produced, rather than
+// marshaling everything first the way WriteJson does. next is called
+// for each item and returns false once there are no more. Output is
+// flushed every StreamFlushEvery items or StreamFlushInterval, whichever
+// comes first, and the stream stops with the context's error when the
+// request is canceled.
+//
+// The status and headers are sent before the first item, so an error
+// part way through can't be reported to the client; the stream is just
+// cut short and the error returned.
+func (t *Tools) StreamJSON(w http.ResponseWriter, r *http.Request, responseStatus int, format StreamFormat, next func() (interface{}, bool, error), headers ...http.Header) error {
+	return t.streamJSON(w, r, responseStatus, format, func(ctx context.Context, _ <-chan time.Time) (interface{}, bool, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		return next()
+	}, headers...)
+}
+
+// StreamJSONChannel is StreamJSON for items arriving on a channel. The
+// stream ends when the channel is closed or the request is canceled.
+// Items still buffered are flushed after StreamFlushInterval even if the
+// channel goes quiet.
+func StreamJSONChannel[T any](t *Tools, w http.ResponseWriter, r *http.Request, responseStatus int, format StreamFormat, items <-chan T, headers ...http.Header) error {
+	return t.streamJSON(w, r, responseStatus, format, func(ctx context.Context, flushDue <-chan time.Time) (interface{}, bool, error) {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-flushDue:
+			return nil, false, errFlushDue
+		case item, ok := <-items:
+			return item, ok, nil
+		}
+	}, headers...)
+}
+
+// errFlushDue is returned by next when buffered items have waited
+// StreamFlushInterval for another one
+var errFlushDue = errors.New("flush due")
+
+// streamJSON does the work for the streaming writers. next gets a
+// channel that fires once buffered items are due to be flushed, and
+// returns errFlushDue if it fires while waiting
+func (t *Tools) streamJSON(w http.ResponseWriter, r *http.Request, responseStatus int, format StreamFormat, next func(context.Context, <-chan time.Time) (interface{}, bool, error), headers ...http.Header) (err error) {
+	start := time.Now()
+	count := 0
+	defer func() {
+		if err != nil {
+			t.logger().Warn("streaming response failed", "request_id", requestID(r), "items", count,
+				"duration", time.Since(start), "error", err)
+			return
+		}
+		t.logger().Debug("streamed response", "request_id", requestID(r), "items", count, "duration", time.Since(start))
+	}()
+
+	flushEvery := 100
+	if t.StreamFlushEvery != 0 {
+		flushEvery = t.StreamFlushEvery
+	}
+	flushInterval := time.Second
+	if t.StreamFlushInterval != 0 {
+		flushInterval = t.StreamFlushInterval
+	}
+
+	// Set custom headers, if any
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	contentType := "application/json"
+	if format == StreamNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(responseStatus)
+
+	rc := http.NewResponseController(w)
+	buf := bufio.NewWriter(w)
+	flush := func() error {
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+		// not every ResponseWriter can flush, and that's fine
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	if format == StreamArray {
+		if err := buf.WriteByte('['); err != nil {
+			return err
+		}
+	}
+
+	ctx := r.Context()
+	pending := 0
+	lastFlush := time.Now()
+	// flushDue is nil, and so never fires, while nothing is buffered
+	var flushTimer *time.Timer
+	var flushDue <-chan time.Time
+	defer func() {
+		if flushTimer != nil {
+			flushTimer.Stop()
+		}
+	}()
+	flushPending := func() error {
+		if err := flush(); err != nil {
+			return err
+		}
+		pending, lastFlush = 0, time.Now()
+		if flushTimer != nil {
+			flushTimer.Stop()
+			flushTimer, flushDue = nil, nil
+		}
+		return nil
+	}
+
+	for {
+		item, ok, err := next(ctx, flushDue)
+		if errors.Is(err, errFlushDue) {
+			if err = flushPending(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			// send whatever we have, so the client sees how far we got
+			_ = flush()
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		out, err := json.Marshal(item)
+		if err != nil {
+			_ = flush()
+			return err
+		}
+
+		if format == StreamArray && count > 0 {
+			_ = buf.WriteByte(',')
+		}
+		if _, err = buf.Write(out); err != nil {
+			return err
+		}
+		if format == StreamNDJSON {
+			_ = buf.WriteByte('\n')
+		}
+
+		count++
+		pending++
+		if pending >= flushEvery || time.Since(lastFlush) >= flushInterval {
+			if err = flushPending(); err != nil {
+				return err
+			}
+		} else if flushTimer == nil {
+			flushTimer = time.NewTimer(flushInterval - time.Since(lastFlush))
+			flushDue = flushTimer.C
+		}
+	}
+
+	if format == StreamArray {
+		_ = buf.WriteByte(']')
+	}
+	return flush()
+}
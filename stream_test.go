@@ -0,0 +1,149 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamRow struct {
+	ID int `json:"id"`
+}
+
+func TestTools_StreamJSON(t *testing.T) {
+	testTools := Tools{StreamFlushEvery: 2}
+
+	for _, format := range []StreamFormat{StreamArray, StreamNDJSON} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		rr := httptest.NewRecorder()
+
+		i := 0
+		next := func() (interface{}, bool, error) {
+			if i == 5 {
+				return nil, false, nil
+			}
+			i++
+			return streamRow{ID: i}, true, nil
+		}
+
+		if err := testTools.StreamJSON(rr, req, http.StatusOK, format, next); err != nil {
+			t.Fatal(err)
+		}
+
+		if !rr.Flushed {
+			t.Error("expected the stream to be flushed")
+		}
+
+		var rows []streamRow
+		switch format {
+		case StreamArray:
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("wrong content type %s", ct)
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &rows); err != nil {
+				t.Errorf("array is not valid JSON: %s", err)
+			}
+		case StreamNDJSON:
+			if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("wrong content type %s", ct)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+				var row streamRow
+				if err := json.Unmarshal([]byte(line), &row); err != nil {
+					t.Errorf("line %q is not valid JSON: %s", line, err)
+				}
+				rows = append(rows, row)
+			}
+		}
+
+		if len(rows) != 5 || rows[4].ID != 5 {
+			t.Errorf("wrong rows streamed: %v", rows)
+		}
+	}
+}
+
+func TestTools_StreamJSONEmpty(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+
+	items := make(chan streamRow)
+	close(items)
+
+	if err := StreamJSONChannel(&testTools, rr, req, http.StatusOK, StreamArray, items); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Body.String() != "[]" {
+		t.Errorf("expected an empty array but got %s", rr.Body.String())
+	}
+}
+
+func TestTools_StreamJSONChannelCanceled(t *testing.T) {
+	var testTools Tools
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+	rr := httptest.NewRecorder()
+
+	// send a couple of rows, then cancel the request while the stream
+	// is waiting for more
+	items := make(chan streamRow)
+	go func() {
+		items <- streamRow{ID: 1}
+		items <- streamRow{ID: 2}
+		cancel()
+	}()
+
+	err := StreamJSONChannel(&testTools, rr, req, http.StatusOK, StreamNDJSON, items)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+
+	if lines := strings.Count(rr.Body.String(), "\n"); lines != 2 {
+		t.Errorf("expected 2 rows before the cancel but got %d", lines)
+	}
+}
+
+// flushRecorder reports what had been written each time it's flushed
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan string
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushed <- f.Body.String()
+}
+
+func TestTools_StreamJSONChannelIdleFlush(t *testing.T) {
+	testTools := Tools{StreamFlushInterval: 10 * time.Millisecond}
+	req, _ := http.NewRequest("GET", "/", nil)
+	rr := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 10)}
+
+	// one row, then nothing until the row has been flushed
+	items := make(chan streamRow)
+	done := make(chan error)
+	go func() {
+		done <- StreamJSONChannel(&testTools, rr, req, http.StatusOK, StreamNDJSON, items)
+	}()
+	items <- streamRow{ID: 1}
+
+	select {
+	case body := <-rr.flushed:
+		if strings.Count(body, "\n") != 1 {
+			t.Errorf("expected the row to be flushed but got %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("row was never flushed while the channel was idle")
+	}
+
+	close(items)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -1,18 +1,20 @@
 package toolkit
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOP0123456789-+"
@@ -27,6 +29,110 @@ type Tools struct {
 	//AllowUnknownFields is true if we're going to permit JSON
 	// that includes unknown fields
 	AllowUnknownFields bool
+	// ProblemJSON makes ErrorJSON send RFC 7807 problem+json documents
+	// instead of the JSONResponse shape
+	ProblemJSON bool
+	// RequireJSONContentType makes ReadJson reject, with a 415, any
+	// request whose Content-Type isn't application/json or +json
+	RequireJSONContentType bool
+	// CompressJSON lets ServeJSON gzip or deflate responses for clients
+	// that accept it
+	CompressJSON bool
+	// CompressMinSize is the smallest body, in bytes, ServeJSON will
+	// compress. Default is 1KiB
+	CompressMinSize int
+	// Encoders are the formats WriteResponse and ReadBody can use.
+	// Default is JSON and XML
+	Encoders *EncoderRegistry
+	// StreamFlushEvery is how many items the streaming writers send
+	// between flushes. Default is 100
+	StreamFlushEvery int
+	// StreamFlushInterval is the longest the streaming writers hold on
+	// to items before flushing. Default is one second
+	StreamFlushInterval time.Duration
+	// MaxNDJSONSize is the largest newline-delimited JSON body, in
+	// bytes, ReadNDJSON will accept. Each record is still limited to
+	// MaxJSONSize. Default is 100MiB
+	MaxNDJSONSize int
+	// DefaultPageSize is the limit ReadPagination uses when the client
+	// doesn't send one. Default is 20
+	DefaultPageSize int
+	// MaxPageSize is the largest limit ReadPagination accepts. Default
+	// is 100
+	MaxPageSize int
+	// HTTPClient is the client PushJSONToRemote uses. Default is
+	// http.DefaultClient
+	HTTPClient *http.Client
+	// RemoteTimeout is how long each PushJSONToRemote attempt may take.
+	// Default is 10 seconds
+	RemoteTimeout time.Duration
+	// RemoteRetries is how many times PushJSONToRemote retries an
+	// idempotent request that failed. Default is 2; -1 turns retries off
+	RemoteRetries int
+	// RemoteBackoff is the wait before the first retry. It doubles on
+	// each retry, with jitter. Default is 100ms
+	RemoteBackoff time.Duration
+	// RemoteMaxRetryWait is the longest PushJSONToRemote waits when
+	// Retry-After asks it to; a longer wait ends the retries. Default is
+	// RemoteTimeout
+	RemoteMaxRetryWait time.Duration
+	// CircuitBreaker, if set, stops PushJSONToRemote calling hosts that
+	// keep failing
+	CircuitBreaker *CircuitBreaker
+	// WebhookSigner, if set, signs every request PushJSONToRemote sends
+	WebhookSigner *WebhookSigner
+	// WebhookVerifier, if set, makes ReadJson, ReadBody, ReadNDJSON,
+	// ReadForm and UploadFiles reject bodies that aren't correctly
+	// signed
+	WebhookVerifier *WebhookVerifier
+	// JSONETags makes ServeJSON and WriteResponse send an ETag computed
+	// from the body, and answer a matching If-None-Match with a 304
+	JSONETags bool
+	// Errors maps errors to the status, message and code ErrorJSON
+	// sends for them
+	Errors *ErrorRegistry
+	// Production makes ErrorJSON hide errors that aren't classified. They
+	// are logged in full and the client gets a generic 500 with a
+	// correlation ID to quote
+	Production bool
+	// Logger receives what the toolkit has to report: uploads, bodies
+	// it couldn't read, remote calls and hidden errors. Default is to
+	// log nothing
+	Logger Logger
+	// RedactFields are the field name patterns whose values are
+	// scrubbed from logs and error messages. They're read once, on first
+	// use. Default is DefaultRedactFields
+	RedactFields []string
+	// MaxJSONDepth is how deeply ReadJson lets objects and arrays nest.
+	// MaxJSONObjectKeys, MaxJSONArrayLen and MaxJSONStringLen limit the
+	// keys in one object, the elements in one array and the bytes in
+	// one string. They're checked by a token pass before anything is
+	// decoded. Zero means no limit
+	MaxJSONDepth      int
+	MaxJSONObjectKeys int
+	MaxJSONArrayLen   int
+	MaxJSONStringLen  int
+	// DisallowDuplicateKeys makes ReadJson reject objects that repeat a
+	// key at any level, instead of keeping the last value. In objects
+	// decoded into structs, keys that differ only in case count as
+	// duplicates, since they fill the same field
+	DisallowDuplicateKeys bool
+	// UseNumber makes ReadJson decode numbers going into interface{}
+	// values as json.Number rather than float64, so large IDs and
+	// amounts keep every digit. Use Decimal for struct fields
+	UseNumber bool
+	// Catalogs holds the translations of the messages in the errors
+	// ReadJson and validation return. Default is the package's catalogs,
+	// added to with RegisterCatalog
+	Catalogs *MessageCatalogs
+	// DefaultLanguage is used when neither the request's context nor its
+	// Accept-Language header names a language with a catalog. Default
+	// is English
+	DefaultLanguage string
+
+	// redactOnce normalizes RedactFields into rd on first use
+	redactOnce sync.Once
+	rd         redactor
 }
 
 // UploadFiles is the type returned to the user
@@ -70,6 +176,7 @@ func (t *Tools) UploadFiles(r *http.Request, dirName string, rename ...bool) ([]
 	}
 
 	var uploadedFiles []*UploadFile
+	start := time.Now()
 
 	if t.MaxFileSize == 0 {
 		t.MaxFileSize = 1024 * 1024 * 1024
@@ -81,6 +188,10 @@ func (t *Tools) UploadFiles(r *http.Request, dirName string, rename ...bool) ([]
 		return nil, err
 	}
 
+	if err = t.verifyForm(r, int64(t.MaxFileSize)); err != nil {
+		return nil, err
+	}
+
 	// check and validate the uploaded file size
 	if err = r.ParseMultipartForm(int64(t.MaxFileSize)); err != nil {
 		return nil, err
@@ -103,6 +214,7 @@ func (t *Tools) UploadFiles(r *http.Request, dirName string, rename ...bool) ([]
 		for _, hdr := range fHeaders {
 			uploadedFiles, err := func([]*UploadFile) ([]*UploadFile, error) {
 				var uploadedFile UploadFile
+				fileStart := time.Now()
 				// hdr = *multipart.FileHeader
 				infile, err := hdr.Open() //multipart.File
 				if err != nil {
@@ -174,6 +286,8 @@ func (t *Tools) UploadFiles(r *http.Request, dirName string, rename ...bool) ([]
 					return nil, err
 				}
 				uploadedFile.FileSize = fileSize
+				t.logger().Info("file uploaded", "request_id", requestID(r), "filename", hdr.Filename,
+					"saved_as", uploadedFile.NewFileName, "size", fileSize, "duration", time.Since(fileStart))
 				//
 				//
 				uploadedFiles = append(uploadedFiles, &uploadedFile)
@@ -181,11 +295,13 @@ func (t *Tools) UploadFiles(r *http.Request, dirName string, rename ...bool) ([]
 			}(uploadedFiles)
 
 			if err != nil {
+				t.logger().Warn("file upload failed", "request_id", requestID(r), "filename", hdr.Filename,
+					"size", hdr.Size, "error", err)
 				return uploadedFiles, err
 			}
 		}
 	}
-	log.Println("upload sucessful")
+	t.logger().Debug("upload finished", "request_id", requestID(r), "files", len(uploadedFiles), "duration", time.Since(start))
 	return uploadedFiles, nil
 
 }
@@ -240,6 +356,7 @@ func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, pathN
 	// So, this header tell the browser to download the file instead of
 	// trying to display it in the browser.
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayedFileName))
+	t.logger().Info("serving download", "request_id", requestID(r), "filename", filePath, "display_name", displayedFileName)
 	http.ServeFile(w, r, filePath)
 }
 
@@ -259,24 +376,79 @@ type JSONResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	// Errors holds the failing fields of a validation error, keyed by
+	// their JSON path
+	Errors map[string]string `json:"errors,omitempty"`
+	// Meta holds the paging details of a list response
+	Meta *PageMeta `json:"meta,omitempty"`
+	// Code is the stable error code from the ErrorRegistry, if any
+	Code string `json:"code,omitempty"`
+	// CorrelationID ties a generic production error to the full error
+	// in the server log
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// RequestID is the ID RequestIDMiddleware gave the request
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// maxJSONBytes is the largest JSON body, or NDJSON record, that's read.
+// Default is 1MiB
+func (t *Tools) maxJSONBytes() int {
+	if t.MaxJSONSize != 0 {
+		return t.MaxJSONSize
+	}
+	return 1024 * 1024
 }
 
 // ReadJSON tries to read the body of a request and converts from json
 // to go data variable
-func (t *Tools) ReadJson(w http.ResponseWriter, r *http.Request, data interface{}) error {
+func (t *Tools) ReadJson(w http.ResponseWriter, r *http.Request, data interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			t.logger().Warn("reading JSON body failed", "request_id", requestID(r),
+				"content_length", r.ContentLength, "duration", time.Since(start), "error", err)
+			// logged in English, returned in the client's language
+			err = t.LocalizeError(r, err)
+			return
+		}
+		t.logger().Debug("read JSON body", "request_id", requestID(r),
+			"content_length", r.ContentLength, "duration", time.Since(start))
+	}()
+
 	// limit the maximum size that a given JSON payload can be just to
 	// avoid someone sending a gigabyte of data to me just in an effort
 	// to bring the server down or something.
-	// default is 1MiB
-	maxBytes := 1024 * 1024 // 1MiB
-	if t.MaxJSONSize != 0 {
-		maxBytes = t.MaxJSONSize
+	maxBytes := t.maxJSONBytes()
+
+	// read the body from the request, undoing any Content-Encoding. The
+	// limit applies again to the decompressed body, so a small gzip bomb
+	// can't get past it
+	body, err := t.openBody(w, r, maxBytes)
+	if err != nil {
+		return err
 	}
+	defer body.Close()
 
-	// read the body from the request
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	// make sure we've been sent JSON, in a charset we can read
+	jsonBody, err := t.jsonBody(r, body)
+	if err != nil {
+		return err
+	}
 
-	dec := json.NewDecoder(r.Body)
+	// a small body can still be a JSON bomb, so the structure is
+	// checked before the decoder builds anything
+	if t.hasJSONLimits() {
+		raw, err := io.ReadAll(jsonBody)
+		if err != nil {
+			return decodeError(err, nil, maxBytes)
+		}
+		if err := t.checkJSONLimits(raw, data); err != nil {
+			return err
+		}
+		jsonBody = bytes.NewReader(raw)
+	}
+
+	dec := json.NewDecoder(jsonBody)
 
 	// check, should we allow people to send JSON to whatever site is
 	// using the service that include fields we don't know about?
@@ -285,6 +457,9 @@ func (t *Tools) ReadJson(w http.ResponseWriter, r *http.Request, data interface{
 		// know about
 		dec.DisallowUnknownFields()
 	}
+	if t.UseNumber {
+		dec.UseNumber()
+	}
 	// buf := make([]byte, 512)
 	// _, err := r.Body.Read(buf)
 	// if err != nil {
@@ -292,80 +467,160 @@ func (t *Tools) ReadJson(w http.ResponseWriter, r *http.Request, data interface{
 	// }
 	// fmt.Println(string(buf[:]))
 	// decode the data
-	err := dec.Decode(data)
+	err = dec.Decode(data)
 	if err != nil {
-		// return err
-
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-
-		switch {
-		case errors.As(err, &syntaxError): // JSON is badly formed
-			log.Println(err.Error())
-			// syntaxError.Offset tell exactly where the character takes place
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			log.Println(err.Error())
-			return errors.New("body contains badly-formed JSON")
-		case errors.As(err, &unmarshalTypeError):
-			log.Println(err.Error())
-			if unmarshalTypeError.Field != "" {
-				// so you tried to send me JSON, that was supposed to be an int,
-				// but it's actually a string, or something like that
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-
-		// what if we have a empty file?
-		// there's no body included
-		case errors.Is(err, io.EOF):
-			log.Println(err.Error())
-			// you try to send me JSON, but there's none there.
-			return errors.New("body must not be empty")
-
-			//this error will never occur if the user actually included
-			// that disallow unknown fields when they instantiated the
-			// variable of the tyoe toolkil.Tools and set that to true
-			// otherwise this error is possible
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			log.Println(err.Error())
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-
-		// maybe the request body is too large
-		case err.Error() == "http: request body too large":
-			log.Println(err.Error())
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-
-		// what if there's an unmarshal error of some sort?
-		case errors.As(err, &invalidUnmarshalError):
-			log.Println(err.Error())
-			return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
-		default:
-			return err
-		}
+		return decodeError(err, data, maxBytes)
 	}
 
 	// check the r.Body contains more than one JSON file
-	// &struct{}{} will try to decode more JSON from that file
-	err = dec.Decode(&struct{}{})
-	// if i get an error that is io.EOF that means there's more than
-	// one JSON value in this body
-	if err != io.EOF {
-		return errors.New("body must contain only one JSON value")
+	// a RawMessage will take whatever JSON value comes next
+	var next json.RawMessage
+	err = dec.Decode(&next)
+	// if i get an error that is io.EOF that means there's only one
+	// JSON value in this body; any other error is a broken body, not a
+	// second value
+	switch {
+	case err == nil:
+		return badRequest("json.multiple_values")
+	case err != io.EOF:
+		return decodeError(err, nil, maxBytes)
+	}
+
+	// the JSON is well formed, now check it against any validate tags
+	// on the target
+	return t.Validate(data)
+}
+
+// decodeError turns an error from the JSON decoder into a message that's
+// fit to send back to the client. data is what was being decoded into,
+// if anything
+func decodeError(err error, data interface{}, maxBytes int) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+
+	switch {
+	case errors.As(err, &syntaxError): // JSON is badly formed
+		// syntaxError.Offset tell exactly where the character takes place
+		return badRequest("json.syntax", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequest("json.malformed")
+	case errors.As(err, &unmarshalTypeError):
+		// a number too big for its field, or a bad decimal, is the
+		// client's mistake, not a type mix-up, so it's reported against
+		// the field
+		if overflow := intOverflowError(unmarshalTypeError); overflow != nil {
+			return overflow
+		}
+		if bad := decimalError(unmarshalTypeError, data); bad != nil {
+			return bad
+		}
+		if unmarshalTypeError.Field != "" {
+			// so you tried to send me JSON, that was supposed to be an int,
+			// but it's actually a string, or something like that
+			return badRequest("json.field_type", unmarshalTypeError.Field)
+		}
+		return badRequest("json.type", unmarshalTypeError.Offset)
+
+	// what if we have a empty file?
+	// there's no body included
+	case errors.Is(err, io.EOF):
+		// you try to send me JSON, but there's none there.
+		return badRequest("json.empty")
+
+		//this error will never occur if the user actually included
+		// that disallow unknown fields when they instantiated the
+		// variable of the tyoe toolkil.Tools and set that to true
+		// otherwise this error is possible
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return badRequest("json.unknown_key", fieldName)
+
+	// maybe the request body is too large
+	case err.Error() == "http: request body too large":
+		return badRequest("json.too_large", maxBytes)
+
+	// what if there's an unmarshal error of some sort?
+	case errors.As(err, &invalidUnmarshalError):
+		return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
+	default:
+		return err
 	}
-	return nil
+}
+
+// ReadJSON is the generic version of ReadJson. Instead of declaring the
+// target variable first and passing a pointer to it, the caller names
+// the type and gets the decoded value back. On error the zero value of
+// T is returned.
+//
+//	payload, err := toolkit.ReadJSON[LoginRequest](&tools, w, r)
+func ReadJSON[T any](t *Tools, w http.ResponseWriter, r *http.Request) (T, error) {
+	var data T
+	if err := t.ReadJson(w, r, &data); err != nil {
+		var zero T
+		return zero, err
+	}
+	return data, nil
+}
+
+// TypedJSONResponse is the generic counterpart of JSONResponse, used on
+// the client side to decode the data member straight into a Go type
+type TypedJSONResponse[T any] struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+	Data    T      `json:"data,omitempty"`
+}
+
+// DecodeJSONResponse reads a JSONResponse envelope, as written by
+// WriteJson and ErrorJSON, from body and returns its data member as a T.
+// If the envelope has error set to true, its message is returned as the
+// error.
+func DecodeJSONResponse[T any](body io.Reader) (T, error) {
+	var zero T
+	var envelope TypedJSONResponse[T]
+
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(&envelope); err != nil {
+		return zero, fmt.Errorf("error decoding JSON response: %w", err)
+	}
+
+	if envelope.Error {
+		// the remote side told us something went wrong, so hand
+		// the message back to the caller as the error
+		return zero, errors.New(envelope.Message)
+	}
+	return envelope.Data, nil
 }
 
 // WriteJson takes a response status code and arbitrary data  and writes
 // json to the client
 func (t *Tools) WriteJson(w http.ResponseWriter, responseStatus int, data interface{}, headers ...http.Header) error {
+	return t.writeJSON(w, nil, responseStatus, data, "application/json", headers...)
+}
+
+// ServeJSON works like WriteJson, but because it has the request it can
+// negotiate with the client. When CompressJSON is set, bodies of at
+// least CompressMinSize bytes are gzip or deflate compressed for clients
+// that send a matching Accept-Encoding header. When JSONETags is set,
+// successful GET and HEAD responses carry an ETag, and a client whose
+// If-None-Match matches it gets 304 Not Modified with no body.
+func (t *Tools) ServeJSON(w http.ResponseWriter, r *http.Request, responseStatus int, data interface{}, headers ...http.Header) error {
+	return t.writeJSON(w, r, responseStatus, data, "application/json", headers...)
+}
+
+// writeJSON marshals data and writes it with the given content type,
+// e.g. application/problem+json for error documents. r may be nil, in
+// which case there's nothing to negotiate
+func (t *Tools) writeJSON(w http.ResponseWriter, r *http.Request, responseStatus int, data interface{}, contentType string, headers ...http.Header) error {
 	out, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	return t.writeBody(w, r, responseStatus, out, contentType, headers...)
+}
 
+// writeBody sends an already encoded body to the client
+func (t *Tools) writeBody(w http.ResponseWriter, r *http.Request, responseStatus int, out []byte, contentType string, headers ...http.Header) error {
 	// Set custom headers, if any
 	if len(headers) > 0 {
 		// deal with one additional header
@@ -374,32 +629,149 @@ func (t *Tools) WriteJson(w http.ResponseWriter, responseStatus int, data interf
 		}
 	}
 
-	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Content-type", contentType)
+
+	// the client may already have this exact body
+	if r != nil && t.notModified(w, r, responseStatus, out) {
+		w.Header().Del("Content-type")
+		w.WriteHeader(http.StatusNotModified)
+		t.logger().Debug("response not modified", "request_id", requestID(r), "etag", w.Header().Get("ETag"))
+		return nil
+	}
+
+	// compress the body if the client can take it
+	if r != nil {
+		var err error
+		out, err = t.compressResponse(w, r, out)
+		if err != nil {
+			return err
+		}
+	}
+
 	// write the response header
 	w.WriteHeader(responseStatus)
 
-	_, err = w.Write(out)
+	_, err := w.Write(out)
 	if err != nil {
+		t.logger().Warn("writing response failed", "request_id", requestID(r), "status", responseStatus, "error", err)
 		return err
 	}
+	t.logger().Debug("response written", "request_id", requestID(r), "status", responseStatus,
+		"content_type", contentType, "size", len(out))
 	return nil
 }
 
 // ErrorJSON takes an error and optionally a status code and generates
-// and sends a JSON error message
+// and sends a JSON error message. If the error supplies its own problem
+// details, or is a *ValidationError, its status is used unless a status
+// code is given, and their detail is the message; validation errors
+// also carry the failing fields in the errors member. Errors in the
+// Errors registry get its status, public message and code instead.
+// When Production is set, errors that are neither registered nor
+// describe themselves are logged and sent as a generic 500 with a
+// correlation ID, whatever status is given; return a *Problem to send
+// a message of your own with another status. The ID set by
+// RequestIDMiddleware is included as request_id. A message taken from
+// the error's own text is scrubbed with RedactString before it's sent;
+// problem details and registered messages are sent as written. When
+// ProblemJSON is set on Tools the error is sent as an RFC 7807
+// application/problem+json document instead.
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
 	// default status code if not provided
 	statusCode := http.StatusBadRequest
+	message := err.Error()
+	var code, correlationID string
+
+	// RequestIDMiddleware has already put the ID on the response
+	reqID := w.Header().Get(RequestIDHeader)
+
+	// known is true for errors that were written with the client in
+	// mind, or that somebody has classified
+	known := false
+	// fromErr is true while the message is the error's own text, rather
+	// than one written for the client
+	fromErr := true
+
+	// an error that describes itself says what the client sees, which
+	// may be less than its Error, e.g. without a remote service's body
+	var problem *Problem
+	var detailer ProblemDetailer
+	if errors.As(err, &detailer) {
+		problem = detailer.ProblemDetails()
+	}
+	if problem != nil {
+		known = true
+		if problem.Status != 0 {
+			statusCode = problem.Status
+		}
+		if problem.Detail != "" {
+			message = problem.Detail
+			fromErr = false
+		}
+	}
+
+	if class, ok := t.Errors.Lookup(err); ok {
+		known = true
+		if class.Status != 0 {
+			statusCode = class.Status
+		}
+		if class.Message != "" {
+			message = class.Message
+			fromErr = false
+			problem = withDetail(problem, message)
+		}
+		code = class.Code
+	}
 
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
+	if t.Production && !known {
+		// we don't know what's in the message, so it stays in our log
+		correlationID = randomID()
+		t.logger().Error("unclassified error hidden from client", "request_id", reqID,
+			"correlation_id", correlationID, "error", err)
+		statusCode = http.StatusInternalServerError
+		message = "internal server error"
+		fromErr = false
+		problem = &Problem{Detail: message}
+	}
+
+	// an error's own text mustn't echo a password or a card number
+	// back. Details and messages written for the client are sent as
+	// they are, since "basic plan" or an order number would otherwise
+	// look like a secret
+	if fromErr {
+		message = t.RedactString(message)
+	}
+
+	if t.ProblemJSON {
+		extra := map[string]interface{}{}
+		if code != "" {
+			extra["code"] = code
+		}
+		if correlationID != "" {
+			extra["correlation_id"] = correlationID
+		}
+		if reqID != "" {
+			extra["request_id"] = reqID
+		}
+		return t.writeProblem(w, statusCode, message, problem, extra)
+	}
+
 	var payload JSONResponse
+
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		payload.Errors = validationError.Fields()
+	}
+
 	payload.Error = true
-	payload.Message = err.Error()
+	payload.Message = message
+	payload.Code = code
+	payload.CorrelationID = correlationID
+	payload.RequestID = reqID
 	return t.WriteJson(w, statusCode, payload)
 
 }
-
-// push JSON to some remote APT or URL and get a response back.
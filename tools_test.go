@@ -394,3 +394,59 @@ func TestTools_ErrorJSON(t *testing.T) {
 		t.Errorf("wrong status code returned; expected 503, but got %d", rr.Code)
 	}
 }
+
+func TestTools_ReadJSONGeneric(t *testing.T) {
+	testTools := Tools{}
+
+	type payload struct {
+		Foo string `json:"foo"`
+	}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo": "bar"}`)))
+	rr := httptest.NewRecorder()
+
+	p, err := ReadJSON[payload](&testTools, rr, req)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.Foo != "bar" {
+		t.Errorf("wrong value decoded; expected bar but got %s", p.Foo)
+	}
+
+	// badly formed JSON should give us an error and the zero value
+	req, _ = http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo": }`)))
+	p, err = ReadJSON[payload](&testTools, rr, req)
+	if err == nil {
+		t.Error("error expected, but none received")
+	}
+	if p.Foo != "" {
+		t.Error("expected zero value on error")
+	}
+}
+
+var decodeResponseTests = []struct {
+	name          string
+	json          string
+	expected      []string
+	errorExpected bool
+}{
+	{name: "data", json: `{"error": false, "message": "ok", "data": ["a", "b"]}`, expected: []string{"a", "b"}, errorExpected: false},
+	{name: "error envelope", json: `{"error": true, "message": "some error"}`, expected: nil, errorExpected: true},
+	{name: "wrong data type", json: `{"error": false, "message": "ok", "data": 1}`, expected: nil, errorExpected: true},
+	{name: "not json", json: `Hello, world`, expected: nil, errorExpected: true},
+}
+
+func TestTools_DecodeJSONResponse(t *testing.T) {
+	for _, e := range decodeResponseTests {
+		data, err := DecodeJSONResponse[[]string](bytes.NewReader([]byte(e.json)))
+		if e.errorExpected && err == nil {
+			t.Errorf("%s: error expected, but none received", e.name)
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: error not expected but one received: %s", e.name, err.Error())
+		}
+		if fmt.Sprint(data) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, data)
+		}
+	}
+}
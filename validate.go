@@ -0,0 +1,318 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes a single field that failed validation
+type FieldError struct {
+	// Field is the JSON path of the field, for example "address.zip"
+	// or "items[2].name"
+	Field string
+	// Rule is the validation rule that failed, for example "required"
+	Rule string
+	// Reason is a human readable explanation of the failure
+	Reason string
+
+	// message is Reason before translation
+	message message
+}
+
+// ValidationError is returned by Validate, and by ReadJson, when one or
+// more fields fail validation. It holds every failing field, not just
+// the first one.
+type ValidationError struct {
+	Errors []FieldError
+
+	// summary replaces "validation failed" once translated, and title
+	// replaces "Validation failed"
+	summary string
+	title   string
+}
+
+// Error lists every failing field with its reason
+func (v *ValidationError) Error() string {
+	var parts []string
+	for _, fe := range v.Errors {
+		parts = append(parts, fmt.Sprintf("%s: %s", fe.Field, fe.Reason))
+	}
+	summary := v.summary
+	if summary == "" {
+		summary = "validation failed"
+	}
+	return summary + ": " + strings.Join(parts, "; ")
+}
+
+// Fields returns the failing fields as a map keyed by JSON path. This is
+// the shape ErrorJSON sends back to the client.
+func (v *ValidationError) Fields() map[string]string {
+	fields := make(map[string]string, len(v.Errors))
+	for _, fe := range v.Errors {
+		// keep the first reason if a field somehow fails twice
+		if _, ok := fields[fe.Field]; !ok {
+			fields[fe.Field] = fe.Reason
+		}
+	}
+	return fields
+}
+
+// add records a failing field, with its reason from the catalogs
+func (v *ValidationError) add(field, rule string, reason message) {
+	v.Errors = append(v.Errors, FieldError{Field: field, Rule: rule, Reason: reason.String(), message: reason})
+}
+
+// Validate checks data against the rules in its validate struct tags and
+// returns a *ValidationError listing every failing field. Rules are
+// comma separated, for example:
+//
+//	Name  string `json:"name" validate:"required,min=3,max=50"`
+//	Email string `json:"email" validate:"required,email"`
+//	Role  string `json:"role" validate:"oneof=admin user guest"`
+//
+// The supported rules are required, min, max, len, email and oneof. For
+// strings, slices and maps min, max and len apply to the length; for
+// numbers they apply to the value. A field that is empty and not
+// required is skipped. Nested structs, pointers and slices of structs
+// are validated too.
+func (t *Tools) Validate(data interface{}) error {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	return validateWith(v, jsonFieldName)
+}
+
+// fieldNamer gives the name a struct field is reported under, and false
+// if the field is skipped
+type fieldNamer func(sf reflect.StructField) (string, bool)
+
+// validateWith runs the validate tags of v, naming fields with names.
+// Validate uses the json names; ReadForm uses the form names
+func validateWith(v reflect.Value, names fieldNamer) error {
+	var ve ValidationError
+	if err := validateValue(v, "", &ve, names); err != nil {
+		return err
+	}
+
+	if len(ve.Errors) > 0 {
+		return &ve
+	}
+	return nil
+}
+
+// validateValue walks v looking for structs to validate. path is the
+// JSON path of v
+func validateValue(v reflect.Value, path string, ve *ValidationError, names fieldNamer) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, path, ve, names)
+	case reflect.Slice, reflect.Array:
+		// raw bytes, e.g. json.RawMessage, have nothing to validate
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), ve, names); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := validateValue(iter.Value(), joinPath(path, fmt.Sprint(iter.Key().Interface())), ve, names); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateStruct applies the validate tags of every exported field of v
+func validateStruct(v reflect.Value, path string, ve *ValidationError, names fieldNamer) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, ok := names(sf)
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+
+		// embedded structs without a json name share the parent's path
+		fieldPath := joinPath(path, name)
+		if sf.Anonymous && sf.Tag.Get("json") == "" {
+			fieldPath = path
+		}
+
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			if err := applyRules(field, fieldPath, tag, ve); err != nil {
+				return fmt.Errorf("field %s: %w", sf.Name, err)
+			}
+		}
+
+		if err := validateValue(field, fieldPath, ve, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRules checks a single field against the rules in tag
+func applyRules(field reflect.Value, path, tag string, ve *ValidationError) error {
+	rules := strings.Split(tag, ",")
+
+	// a nil pointer counts as empty, but a pointer to a zero value
+	// doesn't: that's how callers tell "sent 0" from "left out"
+	empty := field.IsZero()
+	value := field
+	for (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "required" {
+			if empty {
+				ve.add(path, name, msg("validation.required"))
+				return nil
+			}
+			continue
+		}
+
+		// optional fields that were left out are not checked any further
+		if empty {
+			return nil
+		}
+
+		reason, err := checkRule(value, name, param)
+		if err != nil {
+			return err
+		}
+		if reason.code != "" {
+			ve.add(path, name, reason)
+			// one reason per field is plenty
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkRule returns a reason if value breaks the rule, an empty message
+// if it doesn't, and an error if the rule itself is broken
+func checkRule(value reflect.Value, name, param string) (message, error) {
+	switch name {
+	case "min", "max", "len":
+		if value.Type() == decimalType {
+			return checkDecimal(value, name, param)
+		}
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return message{}, fmt.Errorf("invalid %s parameter %q", name, param)
+		}
+		return checkSize(value, name, limit)
+
+	case "email":
+		if value.Kind() != reflect.String {
+			return message{}, errors.New("email rule can only be used on strings")
+		}
+		addr, err := mail.ParseAddress(value.String())
+		if err != nil || addr.Address != value.String() {
+			return msg("validation.email"), nil
+		}
+
+	case "oneof":
+		options := strings.Fields(param)
+		if len(options) == 0 {
+			return message{}, errors.New("oneof rule needs at least one option")
+		}
+		s := fmt.Sprint(value.Interface())
+		for _, o := range options {
+			if s == o {
+				return message{}, nil
+			}
+		}
+		return msg("validation.oneof", strings.Join(options, ", ")), nil
+
+	default:
+		return message{}, fmt.Errorf("unknown validation rule %q", name)
+	}
+	return message{}, nil
+}
+
+// checkSize handles min, max and len. Strings, slices and maps are
+// measured by their length, numbers by their value
+func checkSize(value reflect.Value, name string, limit float64) (message, error) {
+	var n float64
+	length := false
+	switch value.Kind() {
+	case reflect.String:
+		n = float64(len([]rune(value.String())))
+		length = true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n = float64(value.Len())
+		length = true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	default:
+		return message{}, fmt.Errorf("%s rule cannot be used on %s", name, value.Kind())
+	}
+
+	code := "validation." + name
+	if length {
+		code = "validation." + name + "_length"
+	}
+	limitText := strconv.FormatFloat(limit, 'f', -1, 64)
+	switch {
+	case name == "min" && n < limit,
+		name == "max" && n > limit,
+		name == "len" && n != limit:
+		return msg(code, limitText), nil
+	}
+	return message{}, nil
+}
+
+// jsonFieldName returns the name encoding/json uses for a struct field,
+// and false if the field is skipped with json:"-"
+func jsonFieldName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = sf.Name
+	}
+	return name, true
+}
+
+// joinPath appends name to a dotted JSON path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type validateAddress struct {
+	Zip string `json:"zip" validate:"required,len=5"`
+}
+
+type validateUser struct {
+	Name    string            `json:"name" validate:"required,min=3,max=10"`
+	Email   string            `json:"email" validate:"required,email"`
+	Age     int               `json:"age" validate:"min=18,max=130"`
+	Role    string            `json:"role" validate:"oneof=admin user"`
+	Nick    *string           `json:"nick" validate:"max=4"`
+	Address validateAddress   `json:"address"`
+	Others  []validateAddress `json:"others"`
+}
+
+var validateTests = []struct {
+	name     string
+	json     string
+	expected map[string]string
+}{
+	{name: "valid", json: `{"name":"jack","email":"jack@example.com","age":30,"role":"admin","address":{"zip":"12345"}}`, expected: nil},
+	{name: "missing required", json: `{"address":{"zip":"12345"}}`, expected: map[string]string{"name": "is required", "email": "is required"}},
+	{name: "too short", json: `{"name":"ja","email":"jack@example.com","address":{"zip":"12345"}}`, expected: map[string]string{"name": "must have length at least 3"}},
+	{name: "bad email", json: `{"name":"jack","email":"Jack <jack@example.com>","address":{"zip":"12345"}}`, expected: map[string]string{"email": "must be a valid email address"}},
+	{name: "out of range", json: `{"name":"jack","email":"jack@example.com","age":12,"address":{"zip":"12345"}}`, expected: map[string]string{"age": "must be at least 18"}},
+	{name: "bad enum", json: `{"name":"jack","email":"jack@example.com","role":"root","address":{"zip":"12345"}}`, expected: map[string]string{"role": "must be one of: admin, user"}},
+	{name: "pointer", json: `{"name":"jack","email":"jack@example.com","nick":"jackie","address":{"zip":"12345"}}`, expected: map[string]string{"nick": "must have length at most 4"}},
+	{name: "nested", json: `{"name":"jack","email":"jack@example.com","address":{"zip":"1"},"others":[{"zip":"12345"},{}]}`, expected: map[string]string{"address.zip": "must have length exactly 5", "others[1].zip": "is required"}},
+}
+
+func TestTools_Validate(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range validateTests {
+		var u validateUser
+		if err := json.Unmarshal([]byte(e.json), &u); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		err := testTools.Validate(&u)
+		if e.expected == nil {
+			if err != nil {
+				t.Errorf("%s: error not expected but one received: %s", e.name, err.Error())
+			}
+			continue
+		}
+
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%s: expected a validation error but got %v", e.name, err)
+			continue
+		}
+
+		fields := ve.Fields()
+		if len(fields) != len(e.expected) {
+			t.Errorf("%s: expected %d failing fields but got %d: %v", e.name, len(e.expected), len(fields), fields)
+		}
+		for k, v := range e.expected {
+			if fields[k] != v {
+				t.Errorf("%s: field %s: expected %q but got %q", e.name, k, v, fields[k])
+			}
+		}
+	}
+}
+
+func TestTools_ValidateUnknownRule(t *testing.T) {
+	var testTools Tools
+
+	data := struct {
+		Foo string `validate:"shiny"`
+	}{Foo: "bar"}
+
+	err := testTools.Validate(&data)
+	if err == nil {
+		t.Error("expected an error for an unknown rule")
+	}
+
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		t.Error("an unknown rule is a programming error, not a validation error")
+	}
+}
+
+func TestTools_ReadJSONValidation(t *testing.T) {
+	var testTools Tools
+
+	var u validateUser
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"name":"ja"}`)))
+	rr := httptest.NewRecorder()
+
+	err := testTools.ReadJson(rr, req, &u)
+
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected a validation error but got %v", err)
+	}
+
+	// now send it back to the client
+	rr = httptest.NewRecorder()
+	if err = testTools.ErrorJSON(rr, err); err != nil {
+		t.Error(err)
+	}
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code returned; expected 422, but got %d", rr.Code)
+	}
+
+	var payload JSONResponse
+	if err = json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Error("received error when decoding JSON", err)
+	}
+
+	if payload.Errors["name"] != "must have length at least 3" || payload.Errors["email"] != "is required" {
+		t.Errorf("wrong field errors returned: %v", payload.Errors)
+	}
+}
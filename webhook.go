@@ -0,0 +1,155 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers a signed webhook carries. The signature is
+// "v1=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the
+// body, so a signature can't be replayed with a different timestamp.
+// Several signatures may be sent, separated by commas.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+var (
+	// ErrWebhookSignature means the signature was missing or matched
+	// none of the secrets
+	ErrWebhookSignature = errors.New("webhook signature is missing or invalid")
+	// ErrWebhookExpired means the timestamp was missing, or too far
+	// from now to accept
+	ErrWebhookExpired = errors.New("webhook timestamp is missing or outside the allowed window")
+)
+
+// WebhookError is returned when a webhook fails verification. It wraps
+// ErrWebhookSignature or ErrWebhookExpired, and ErrorJSON sends it as a
+// 401.
+type WebhookError struct {
+	Err error
+}
+
+func (e *WebhookError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *WebhookError) Unwrap() error {
+	return e.Err
+}
+
+// ProblemDetails reports a failed verification as 401 Unauthorized
+func (e *WebhookError) ProblemDetails() *Problem {
+	return &Problem{Status: http.StatusUnauthorized, Detail: e.Error()}
+}
+
+// WebhookSigner signs outgoing webhook bodies. Set it on
+// Tools.WebhookSigner and PushJSONToRemote signs every request, or call
+// Sign yourself.
+type WebhookSigner struct {
+	Secret []byte
+}
+
+// Sign returns the timestamp and signature headers for body, sent at now
+func (s *WebhookSigner) Sign(body []byte, now time.Time) http.Header {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	header := make(http.Header)
+	header.Set(WebhookTimestampHeader, timestamp)
+	header.Set(WebhookSignatureHeader, "v1="+hex.EncodeToString(webhookMAC(s.Secret, timestamp, body)))
+	return header
+}
+
+// WebhookVerifier checks incoming webhook signatures. Set it on
+// Tools.WebhookVerifier and ReadJson rejects, with a 401, any body that
+// isn't signed with one of Secrets or whose timestamp is stale. Keep the
+// old secret in Secrets while senders move to the new one.
+type WebhookVerifier struct {
+	Secrets [][]byte
+	// Tolerance is how far the timestamp may be from now, either way,
+	// before the request is treated as a replay. Default is five minutes
+	Tolerance time.Duration
+	// MaxBodySize is the largest signed body that's read, since it has
+	// to be held in memory to be checked. It lowers the limit of
+	// UploadFiles and ReadForm. Default is 10MiB
+	MaxBodySize int64
+}
+
+// Verify checks the signature headers in header against body, which
+// must be exactly the bytes that were signed
+func (v *WebhookVerifier) Verify(header http.Header, body []byte) error {
+	timestamp, sigs, err := v.checkHeaders(header)
+	if err != nil {
+		return err
+	}
+
+	for _, sent := range sigs {
+		for _, secret := range v.Secrets {
+			// hmac.Equal takes the same time whether or not the
+			// signatures match
+			if hmac.Equal(sent, webhookMAC(secret, timestamp, body)) {
+				return nil
+			}
+		}
+	}
+	return &WebhookError{Err: ErrWebhookSignature}
+}
+
+// checkHeaders checks what can be checked before the body is read: that
+// the timestamp is fresh and there's at least one signature. It returns
+// the timestamp and the decoded signatures
+func (v *WebhookVerifier) checkHeaders(header http.Header) (string, [][]byte, error) {
+	tolerance := 5 * time.Minute
+	if v.Tolerance != 0 {
+		tolerance = v.Tolerance
+	}
+
+	timestamp := header.Get(WebhookTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", nil, &WebhookError{Err: ErrWebhookExpired}
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return "", nil, &WebhookError{Err: fmt.Errorf("%w: sent %s ago", ErrWebhookExpired, age.Round(time.Second))}
+	}
+
+	var sigs [][]byte
+	for _, sig := range strings.Split(header.Get(WebhookSignatureHeader), ",") {
+		version, value, _ := strings.Cut(strings.TrimSpace(sig), "=")
+		if version != "v1" {
+			continue
+		}
+		if sent, err := hex.DecodeString(value); err == nil {
+			sigs = append(sigs, sent)
+		}
+	}
+	if len(sigs) == 0 {
+		return "", nil, &WebhookError{Err: ErrWebhookSignature}
+	}
+	return timestamp, sigs, nil
+}
+
+// maxBodySize is the largest signed body that's read
+func (v *WebhookVerifier) maxBodySize() int64 {
+	if v.MaxBodySize != 0 {
+		return v.MaxBodySize
+	}
+	return 10 * 1024 * 1024
+}
+
+// webhookMAC computes the signature of body sent at timestamp
+func webhookMAC(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
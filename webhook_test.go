@@ -0,0 +1,172 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var webhookTests = []struct {
+	name        string
+	secret      string
+	sentAt      time.Duration
+	body        string
+	expectedErr error
+}{
+	{name: "valid", secret: "new", body: `{"event":"paid"}`},
+	{name: "old secret", secret: "old", body: `{"event":"paid"}`},
+	{name: "wrong secret", secret: "guess", body: `{"event":"paid"}`, expectedErr: ErrWebhookSignature},
+	{name: "tampered body", secret: "new", body: `{"event":"refunded"}`, expectedErr: ErrWebhookSignature},
+	{name: "stale", secret: "new", sentAt: -10 * time.Minute, body: `{"event":"paid"}`, expectedErr: ErrWebhookExpired},
+	{name: "future", secret: "new", sentAt: 10 * time.Minute, body: `{"event":"paid"}`, expectedErr: ErrWebhookExpired},
+}
+
+func TestWebhookVerifier_Verify(t *testing.T) {
+	verifier := WebhookVerifier{Secrets: [][]byte{[]byte("new"), []byte("old")}}
+
+	for _, e := range webhookTests {
+		signer := WebhookSigner{Secret: []byte(e.secret)}
+		header := signer.Sign([]byte(`{"event":"paid"}`), time.Now().Add(e.sentAt))
+
+		err := verifier.Verify(header, []byte(e.body))
+		if e.expectedErr == nil && err != nil {
+			t.Errorf("%s: error not expected but one received: %s", e.name, err)
+		}
+		if e.expectedErr != nil && !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expectedErr, err)
+		}
+	}
+
+	if err := verifier.Verify(http.Header{}, []byte("{}")); !errors.Is(err, ErrWebhookExpired) {
+		t.Errorf("missing headers: expected an error but got %v", err)
+	}
+}
+
+func TestTools_ReadJsonWebhook(t *testing.T) {
+	testTools := Tools{WebhookVerifier: &WebhookVerifier{Secrets: [][]byte{[]byte("secret")}}}
+	signer := WebhookSigner{Secret: []byte("secret")}
+	body := []byte(`{"event":"paid"}`)
+
+	var payload struct {
+		Event string `json:"event"`
+	}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader(body))
+	for key, value := range signer.Sign(body, time.Now()) {
+		req.Header[key] = value
+	}
+	if err := testTools.ReadJson(httptest.NewRecorder(), req, &payload); err != nil || payload.Event != "paid" {
+		t.Errorf("signed body rejected: %v, %+v", err, payload)
+	}
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewReader(body))
+	err := testTools.ReadJson(httptest.NewRecorder(), req, &payload)
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an unsigned body but got %d (%v)", rr.Code, err)
+	}
+}
+
+var webhookReaderTests = []struct {
+	name        string
+	contentType string
+	body        string
+	read        func(testTools *Tools, w http.ResponseWriter, r *http.Request) error
+}{
+	{name: "ReadBody", contentType: "application/xml", body: `<event><name>paid</name></event>`,
+		read: func(testTools *Tools, w http.ResponseWriter, r *http.Request) error {
+			var payload struct {
+				Name string `xml:"name"`
+			}
+			return testTools.ReadBody(w, r, &payload)
+		}},
+	{name: "ReadNDJSON", contentType: "application/x-ndjson", body: "{\"name\":\"paid\"}\n",
+		read: func(testTools *Tools, w http.ResponseWriter, r *http.Request) error {
+			return ReadNDJSON(testTools, w, r, func(line int, record map[string]string) error { return nil })
+		}},
+	{name: "ReadForm", contentType: "application/x-www-form-urlencoded", body: "name=paid",
+		read: func(testTools *Tools, w http.ResponseWriter, r *http.Request) error {
+			var payload struct {
+				Name string `form:"name"`
+			}
+			return testTools.ReadForm(r, &payload)
+		}},
+}
+
+func TestTools_ReadersWebhook(t *testing.T) {
+	testTools := Tools{WebhookVerifier: &WebhookVerifier{Secrets: [][]byte{[]byte("secret")}}}
+	signer := WebhookSigner{Secret: []byte("secret")}
+
+	for _, e := range webhookReaderTests {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+		for key, value := range signer.Sign([]byte(e.body), time.Now()) {
+			req.Header[key] = value
+		}
+		if err := e.read(&testTools, httptest.NewRecorder(), req); err != nil {
+			t.Errorf("%s: signed body rejected: %v", e.name, err)
+		}
+
+		// an unsigned body is turned away without being read
+		body := &countingReader{r: strings.NewReader(e.body)}
+		req, _ = http.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", e.contentType)
+		if err := e.read(&testTools, httptest.NewRecorder(), req); !errors.Is(err, ErrWebhookExpired) {
+			t.Errorf("%s: expected an unsigned body to be rejected but got %v", e.name, err)
+		}
+		if body.n != 0 {
+			t.Errorf("%s: %d bytes of an unsigned body were read", e.name, body.n)
+		}
+	}
+
+	// signed forms are held to the verifier's limit, not MaxFileSize
+	testTools.WebhookVerifier.MaxBodySize = 16
+	form := "name=" + strings.Repeat("a", 64)
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, value := range signer.Sign([]byte(form), time.Now()) {
+		req.Header[key] = value
+	}
+	var payload struct {
+		Name string `form:"name"`
+	}
+	if err := testTools.ReadForm(req, &payload); err == nil || err.Error() != "body must not be larger than 16 bytes" {
+		t.Errorf("expected a signed form over the limit to be rejected but got %v", err)
+	}
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestTools_PushJSONToRemoteSigned(t *testing.T) {
+	verifier := WebhookVerifier{Secrets: [][]byte{[]byte("secret")}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.Verify(r.Header, body); err != nil {
+			t.Errorf("signature not accepted: %s", err)
+		}
+	}))
+	defer srv.Close()
+
+	testTools := Tools{WebhookSigner: &WebhookSigner{Secret: []byte("secret")}}
+	if _, err := testTools.PushJSONToRemote(context.Background(), "POST", srv.URL, map[string]string{"event": "paid"}, nil); err != nil {
+		t.Error(err)
+	}
+}